@@ -0,0 +1,46 @@
+package pagedfile
+
+// Takes a frame out of the pool as a scratch block of the frame size, which is not backed by any file.
+// The frame is the first free one, or the frame of a page evicted like on a miss. It is cleared, is never evicted,
+// and counts against the frames of the pool until it is given back with `DisposeBlock`.
+// Frames reserved for files are not used. If every other frame is pinned, error `ErrNoAvailablePage` is returned.
+func (bp *BufferPool) AllocateBlock() ([]byte, error) {
+	bp.latch.Lock()
+	page, err := bp.findAvailablePage(nil)
+	if err != nil {
+		bp.latch.Unlock()
+		return nil, err
+	}
+	bp.removeFree(page)
+	page.store = nil
+	page.file = nil
+	for i := range page.frame {
+		page.frame[i] = 0
+	}
+	bp.blocks[&page.frame[0]] = page
+	unsynced := bp.takeUnsynced()
+	bp.latch.Unlock()
+
+	if errs := syncWritten(unsynced); len(errs) > 0 {
+		bp.DisposeBlock(page.frame)
+		return nil, errs[0]
+	}
+	return page.frame, nil
+}
+
+// Gives back a block obtained from `AllocateBlock`, returning its frame to the free queue.
+// The block must not be used anymore. If it is not a block of the pool, error `ErrInvalidBlock` is returned.
+func (bp *BufferPool) DisposeBlock(block []byte) error {
+	if len(block) == 0 {
+		return ErrInvalidBlock
+	}
+	bp.latch.Lock()
+	defer bp.latch.Unlock()
+	page, ok := bp.blocks[&block[0]]
+	if !ok {
+		return ErrInvalidBlock
+	}
+	delete(bp.blocks, &block[0])
+	bp.makeHeadFree(page)
+	return nil
+}
@@ -0,0 +1,57 @@
+package pagedfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScratchBlocks(t *testing.T) {
+	pool := NewBufferPool(3)
+	_, fh := utilsOpenNewFile(t, pool)
+	page, err := fh.AllocatePage()
+	assert.Nil(t, err, "allocate page")
+	assert.Nil(t, page.WriteInt32(0, 42), "write page")
+	assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page")
+
+	var blocks [][]byte
+	for i := 0; i < 3; i++ {
+		block, err := pool.AllocateBlock()
+		assert.Nil(t, err, "allocate block", i)
+		assert.Equal(t, PageSize, len(block), "size of block", i)
+		assert.Equal(t, make([]byte, PageSize), block, "block is cleared", i)
+		block[0] = byte(i + 1)
+		blocks = append(blocks, block)
+	}
+	assert.Equal(t, 0, len(pool.cache), "pages are evicted for blocks")
+	utilsCheckPoolLists(t, pool, "all frames are blocks")
+	stats := pool.Stats()
+	assert.Equal(t, 3, stats.Blocks, "blocks in stats")
+	assert.Equal(t, 0, stats.FreeFrames, "no free frame")
+
+	_, err = pool.AllocateBlock()
+	assert.ErrorIs(t, err, ErrNoAvailablePage, "blocks are never evicted")
+	_, err = fh.GetThisPage(1)
+	assert.ErrorIs(t, err, ErrNoAvailablePage, "blocks are not available to pages")
+	assert.ErrorIs(t, pool.Resize(2), ErrTooManyPinned, "blocks are kept when shrinking")
+
+	assert.ErrorIs(t, pool.DisposeBlock(make([]byte, PageSize)), ErrInvalidBlock, "dispose foreign block")
+	assert.ErrorIs(t, pool.DisposeBlock(nil), ErrInvalidBlock, "dispose empty block")
+	assert.Nil(t, pool.DisposeBlock(blocks[1]), "dispose block")
+	assert.ErrorIs(t, pool.DisposeBlock(blocks[1]), ErrInvalidBlock, "dispose block twice")
+	utilsCheckPoolLists(t, pool, "one block disposed")
+
+	page, err = fh.GetThisPage(1)
+	assert.Nil(t, err, "get page in disposed block")
+	v, err := page.ReadInt32(0)
+	assert.Nil(t, err, "read page")
+	assert.Equal(t, int32(42), v, "content of page written back before the frame became a block")
+	assert.Nil(t, fh.UnpinPage(1), "unpin page")
+	assert.Equal(t, byte(1), blocks[0][0], "other blocks are untouched")
+	assert.Equal(t, byte(3), blocks[2][0], "other blocks are untouched")
+
+	assert.Nil(t, pool.DisposeBlock(blocks[0]), "dispose block")
+	assert.Nil(t, pool.DisposeBlock(blocks[2]), "dispose block")
+	utilsCheckPoolLists(t, pool, "all blocks disposed")
+	assert.Nil(t, fh.Close(), "close file")
+}
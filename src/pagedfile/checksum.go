@@ -0,0 +1,38 @@
+package pagedfile
+
+import "hash/crc32"
+
+const (
+	pageTrailerSize = 4 // size of the checksum at the end of each page of a file with checksums enabled
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Computes the CRC32C checksum of a page, excluding its trailer.
+func pageChecksum(buf []byte) uint32 {
+	return crc32.Checksum(buf[:len(buf)-pageTrailerSize], crc32cTable)
+}
+
+// Stores the checksum of a page in its trailer.
+func setPageChecksum(buf []byte) {
+	RWBytesOrder.PutUint32(buf[len(buf)-pageTrailerSize:], pageChecksum(buf))
+}
+
+// Checks the checksum stored in the trailer of page `num` of a store.
+// A page consisting only of zeros is considered valid if it lies beyond the end of the store, since it has never
+// been written. Within the store, it is not: zeroed sectors are a common kind of corruption.
+func pageChecksumValid(store PageStore, num TypePageNum, buf []byte) (bool, error) {
+	if RWBytesOrder.Uint32(buf[len(buf)-pageTrailerSize:]) == pageChecksum(buf) {
+		return true, nil
+	}
+	for _, b := range buf {
+		if b != 0 {
+			return false, nil
+		}
+	}
+	size, err := store.Size()
+	if err != nil {
+		return false, err
+	}
+	return int64(num)*int64(len(buf)) >= size, nil
+}
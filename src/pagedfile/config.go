@@ -3,9 +3,16 @@ package pagedfile
 import "encoding/binary"
 
 const (
-	PageSize = 4096
+	PageSize    = 4096  // default page size of files and frame size of buffer pools
+	MinPageSize = 512   // smallest page size a file can have
+	MaxPageSize = 65536 // largest page size a file can have
 )
 
+// Checks whether a page size is a power of two between `MinPageSize` and `MaxPageSize`.
+func validPageSize(size int) bool {
+	return size >= MinPageSize && size <= MaxPageSize && size&(size-1) == 0
+}
+
 var (
 	RWBytesOrder = binary.BigEndian
 )
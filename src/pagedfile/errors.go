@@ -1,6 +1,10 @@
 package pagedfile
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 var (
 	ErrPageBeingUsed       = errors.New("The page is being used.")
@@ -8,4 +12,124 @@ var (
 	ErrPageAlreadyInBuffer = errors.New("The page is already in buffer pool.")
 	ErrPageNotInBuffer     = errors.New("The page is not in buffer pool.")
 	ErrPageNotInUse        = errors.New("The page is not in use.")
+	ErrInvalidPageNum      = errors.New("The page number is invalid.")
+	ErrPageDisposed        = errors.New("The page has been disposed.")
+	ErrEndOfFile           = errors.New("There are no more pages in the file.")
+	ErrInvalidOffset       = errors.New("The offset is out of the page.")
+	ErrNotPagedFile        = errors.New("The file is not a paged file.")
+	ErrUnsupportedVersion  = errors.New("The file format version is not supported.")
+	ErrPageSizeMismatch    = errors.New("The page size of the file is larger than the frames of buffer pool.")
+	ErrInvalidPageSize     = errors.New("The page size is invalid.")
+	ErrStoreNotEmpty       = errors.New("The page store is not empty.")
+	ErrBadFileHeader       = errors.New("The file header is invalid.")
+	ErrInvalidPoolSize     = errors.New("The size of buffer pool is invalid.")
+	ErrTooManyPinned       = errors.New("Too many pages are pinned to shrink the buffer pool.")
+	ErrInvalidBlock        = errors.New("The block is not allocated from the buffer pool.")
+	ErrInvalidQuota        = errors.New("The frame quota is invalid.")
+	ErrFrameQuotaExceeded  = errors.New("The file holds as many frames as its quota allows, all pinned.")
 )
+
+// PageError records an error and the operation, file and page that caused it.
+// Errors of buffer pools and file handlers concerning a file are of this type; use `errors.Is` to compare them
+// with the sentinel errors above, and `errors.As` to get the underlying error.
+type PageError struct {
+	Op   string      // operation that failed, such as "get" or "allocate"
+	File string      // name of the file
+	Page TypePageNum // page number in the file, `NonExistPageNum` if the error does not concern a single page
+	Err  error       // underlying error
+}
+
+func (e *PageError) Error() string {
+	if e.Page == NonExistPageNum {
+		return fmt.Sprintf("%s file %s: %v", e.Op, e.File, e.Err)
+	}
+	return fmt.Sprintf("%s page %d of file %s: %v", e.Op, e.Page, e.File, e.Err)
+}
+
+func (e *PageError) Unwrap() error {
+	return e.Err
+}
+
+// Wraps an error into a `*PageError` with given context.
+// It returns nil if err is nil, and err itself if it already carries a `*PageError`, which has more precise context.
+func wrapPageError(op string, file string, num TypePageNum, err error) error {
+	if err == nil {
+		return nil
+	}
+	var pageErr *PageError
+	if errors.As(err, &pageErr) {
+		return err
+	}
+	return &PageError{Op: op, File: file, Page: num, Err: err}
+}
+
+// Like `wrapPageError`, but an error which is a `*PageError` itself gets given operation, keeping its file, page
+// and underlying error, so that public methods report their own operation rather than the inner step that failed.
+// A `*FlushError` is returned as it is, since each of its failures tells its own operation.
+func withPageOp(op string, file string, num TypePageNum, err error) error {
+	if pageErr, ok := err.(*PageError); ok {
+		return &PageError{Op: op, File: pageErr.File, Page: pageErr.Page, Err: pageErr.Err}
+	}
+	return wrapPageError(op, file, num, err)
+}
+
+// ErrPageCorrupted is the underlying error of reading a page whose checksum does not match its content.
+type ErrPageCorrupted struct {
+	File string      // name of the file
+	Page TypePageNum // page number in the file
+}
+
+func (e *ErrPageCorrupted) Error() string {
+	return fmt.Sprintf("Page %d of file %s is corrupted.", e.Page, e.File)
+}
+
+// ErrPagesPinned replaces `ErrPageBeingUsed` as the underlying error when pages of a file cannot be released because
+// they are pinned, if the pool tracks pins. It lists the pinned pages and where they were pinned.
+type ErrPagesPinned struct {
+	File  string       // name of the file
+	Pages []PinnedPage // pinned pages of the file
+}
+
+func (e *ErrPagesPinned) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d pages of file %s are still pinned.", len(e.Pages), e.File)
+	for _, p := range e.Pages {
+		b.WriteString("\n")
+		b.WriteString(p.String())
+	}
+	return b.String()
+}
+
+// Makes `errors.Is(err, ErrPageBeingUsed)` hold.
+func (e *ErrPagesPinned) Unwrap() error {
+	return ErrPageBeingUsed
+}
+
+// FlushError aggregates the failures of writing back or syncing several pages.
+// Every failure is a `*PageError`, telling the page that failed to be written, or the file that failed to be synced.
+type FlushError struct {
+	Errors []error
+}
+
+// Returns a `*FlushError` holding given errors, or nil if there is none.
+func newFlushError(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return &FlushError{Errors: errs}
+}
+
+func (e *FlushError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d pages or files failed to be flushed.", len(e.Errors))
+	for _, err := range e.Errors {
+		b.WriteString("\n")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// Makes `errors.Is` and `errors.As` match any of the failures.
+func (e *FlushError) Unwrap() []error {
+	return e.Errors
+}
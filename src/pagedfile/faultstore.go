@@ -0,0 +1,269 @@
+package pagedfile
+
+import (
+	"io"
+	"sync"
+	"syscall"
+)
+
+// FaultOp is a kind of operation on a page store.
+type FaultOp int
+
+const (
+	OpRead FaultOp = iota
+	OpWrite
+	OpSync
+	OpDataSync
+	OpWritePages // calls of `WritePages`, only counted; faults are injected into the writes of the pages instead
+)
+
+// FaultKind is a failure injected by FaultyStore.
+type FaultKind int
+
+const (
+	FaultShortRead  FaultKind = iota // only the first half of the page is read, and `io.ErrUnexpectedEOF` is returned
+	FaultShortWrite                  // only the first half of the page is written, and `io.ErrShortWrite` is returned
+	FaultNoSpace                     // nothing is written, and `syscall.ENOSPC` is returned
+	FaultSyncError                   // nothing becomes durable, and `syscall.EIO` is returned; applies to both kinds of sync
+)
+
+// Fault schedules failures of the operations of a FaultyStore.
+// The first `After` matching operations succeed, then the following `Count` matching operations fail.
+type Fault struct {
+	Op    FaultOp
+	Kind  FaultKind
+	Pages []TypePageNum // pages whose reads or writes match, all pages if empty
+	After int           // number of matching operations to let through first
+	Count int           // number of failing operations, or 0 to fail all following matching operations
+}
+
+func (f *Fault) matches(op FaultOp, num TypePageNum) bool {
+	if f.Op != op {
+		return false
+	}
+	if op == OpSync || op == OpDataSync || len(f.Pages) == 0 {
+		return true
+	}
+	for _, page := range f.Pages {
+		if page == num {
+			return true
+		}
+	}
+	return false
+}
+
+// FaultyStore wraps a page store, and injects failures into its operations according to scheduled faults.
+// Writes are kept in memory until `Sync` succeeds, so that `Crash` can drop every write that is not durable yet.
+// Closing a FaultyStore neither closes the underlying store nor drops pending writes.
+type FaultyStore struct {
+	store   PageStore // durable content
+	latch   sync.Mutex
+	pending []pendingWrite  // writes not synced yet, in order
+	faults  []*Fault        // scheduled faults, in order of scheduling
+	ops     map[FaultOp]int // number of operations attempted, by kind
+	failed  map[FaultOp]int // number of operations failed, by kind
+}
+
+// pendingWrite is a write kept in memory by a FaultyStore until it is synced.
+type pendingWrite struct {
+	offset int64
+	data   []byte
+}
+
+func (w *pendingWrite) end() int64 {
+	return w.offset + int64(len(w.data))
+}
+
+func NewFaultyStore(store PageStore) *FaultyStore {
+	return &FaultyStore{
+		store:  store,
+		ops:    make(map[FaultOp]int),
+		failed: make(map[FaultOp]int),
+	}
+}
+
+// Schedules a fault. Faults are matched in order of scheduling.
+func (s *FaultyStore) Inject(fault Fault) {
+	s.latch.Lock()
+	defer s.latch.Unlock()
+	s.faults = append(s.faults, &fault)
+}
+
+// Removes all scheduled faults.
+func (s *FaultyStore) ClearFaults() {
+	s.latch.Lock()
+	defer s.latch.Unlock()
+	s.faults = nil
+}
+
+// Simulates a crash: every write that has not been synced is lost.
+func (s *FaultyStore) Crash() {
+	s.latch.Lock()
+	defer s.latch.Unlock()
+	s.pending = nil
+}
+
+// Returns the number of operations of given kind attempted so far, including failed ones.
+func (s *FaultyStore) Ops(op FaultOp) int {
+	s.latch.Lock()
+	defer s.latch.Unlock()
+	return s.ops[op]
+}
+
+// Returns the number of operations of given kind that failed because of injected faults.
+func (s *FaultyStore) Failed(op FaultOp) int {
+	s.latch.Lock()
+	defer s.latch.Unlock()
+	return s.failed[op]
+}
+
+// Counts an operation, and returns the fault it triggers, if any.
+func (s *FaultyStore) trigger(op FaultOp, num TypePageNum) (FaultKind, bool) {
+	s.ops[op] += 1
+	for i, f := range s.faults {
+		if !f.matches(op, num) {
+			continue
+		}
+		if f.After > 0 {
+			f.After -= 1
+			continue
+		}
+		if f.Count > 0 {
+			f.Count -= 1
+			if f.Count == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		s.failed[op] += 1
+		return f.Kind, true
+	}
+	return 0, false
+}
+
+func (s *FaultyStore) Name() string {
+	return s.store.Name()
+}
+
+func (s *FaultyStore) ReadPage(num TypePageNum, buf []byte) error {
+	s.latch.Lock()
+	defer s.latch.Unlock()
+	kind, fail := s.trigger(OpRead, num)
+	err := s.store.ReadPage(num, buf)
+	if err != nil {
+		return err
+	}
+	offset := int64(num) * int64(len(buf))
+	end := offset + int64(len(buf))
+	for _, w := range s.pending {
+		if w.offset < end && w.end() > offset {
+			from := offset
+			if w.offset > from {
+				from = w.offset
+			}
+			copy(buf[from-offset:], w.data[from-w.offset:])
+		}
+	}
+	if fail && kind == FaultShortRead {
+		for i := len(buf) / 2; i < len(buf); i++ {
+			buf[i] = 0
+		}
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+func (s *FaultyStore) WritePage(num TypePageNum, buf []byte) error {
+	s.latch.Lock()
+	defer s.latch.Unlock()
+	return s.writePage(num, buf)
+}
+
+// Writes consecutive pages one by one, each counting as an operation `OpWrite`, and stops at the first failure.
+// Every call is also counted as an operation `OpWritePages`.
+func (s *FaultyStore) WritePages(num TypePageNum, bufs [][]byte) (int, error) {
+	s.latch.Lock()
+	defer s.latch.Unlock()
+	s.ops[OpWritePages] += 1
+	for i, buf := range bufs {
+		err := s.writePage(num+TypePageNum(i), buf)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(bufs), nil
+}
+
+// Writes a page, unless a fault is triggered. The caller should hold the latch.
+func (s *FaultyStore) writePage(num TypePageNum, buf []byte) error {
+	kind, fail := s.trigger(OpWrite, num)
+	if fail && kind == FaultNoSpace {
+		return syscall.ENOSPC
+	}
+	data := buf
+	if fail && kind == FaultShortWrite {
+		data = buf[:len(buf)/2]
+	}
+	w := pendingWrite{offset: int64(num) * int64(len(buf)), data: append([]byte(nil), data...)}
+	// drop the pending writes overwritten entirely
+	kept := s.pending[:0]
+	for _, prev := range s.pending {
+		if prev.offset < w.offset || prev.end() > w.end() {
+			kept = append(kept, prev)
+		}
+	}
+	s.pending = append(kept, w)
+	if len(data) < len(buf) {
+		return io.ErrShortWrite
+	}
+	return nil
+}
+
+func (s *FaultyStore) Size() (int64, error) {
+	s.latch.Lock()
+	defer s.latch.Unlock()
+	size, err := s.store.Size()
+	if err != nil {
+		return 0, err
+	}
+	for _, w := range s.pending {
+		if w.end() > size {
+			size = w.end()
+		}
+	}
+	return size, nil
+}
+
+// Makes pending writes durable by writing them to the underlying store and syncing it.
+func (s *FaultyStore) Sync() error {
+	return s.sync(OpSync)
+}
+
+// Makes pending writes durable like `Sync`, but data-syncs the underlying store, and counts as `OpDataSync`.
+func (s *FaultyStore) DataSync() error {
+	return s.sync(OpDataSync)
+}
+
+func (s *FaultyStore) sync(op FaultOp) error {
+	s.latch.Lock()
+	defer s.latch.Unlock()
+	kind, fail := s.trigger(op, NonExistPageNum)
+	if fail && kind == FaultSyncError {
+		return syscall.EIO
+	}
+	for len(s.pending) > 0 {
+		w := s.pending[0]
+		err := s.store.WritePage(TypePageNum(w.offset/int64(len(w.data))), w.data)
+		if err != nil {
+			return err
+		}
+		s.pending = s.pending[1:]
+	}
+	if op == OpDataSync {
+		return s.store.DataSync()
+	}
+	return s.store.Sync()
+}
+
+func (s *FaultyStore) Close() error {
+	return nil
+}
@@ -0,0 +1,261 @@
+package pagedfile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Checks that the used and free queues are well-formed, hold every frame exactly once,
+// and that every cached page is in the used queue.
+func utilsCheckPoolLists(t *testing.T, pool *BufferPool, desc string) {
+	seen := make(map[*BufferedPage]bool)
+	used := make(map[*BufferedPage]bool)
+	var last *BufferedPage
+	for pos := pool.headUsed; pos != nil; pos = pos.next {
+		assert.Equal(t, last, pos.prev, "prev link of used page", pos.idx, desc)
+		assert.False(t, seen[pos], "page appears once", pos.idx, desc)
+		seen[pos] = true
+		used[pos] = true
+		last = pos
+	}
+	assert.Equal(t, last, pool.tailUsed, "tail of used queue", desc)
+	last = nil
+	for pos := pool.headFree; pos != nil; pos = pos.next {
+		assert.Equal(t, last, pos.prev, "prev link of free page", pos.idx, desc)
+		assert.False(t, seen[pos], "page appears once", pos.idx, desc)
+		seen[pos] = true
+		last = pos
+	}
+	for _, page := range pool.blocks {
+		assert.False(t, seen[page], "block is in no queue", page.idx, desc)
+		seen[page] = true
+	}
+	assert.Equal(t, len(pool.buffer), len(seen), "every frame is in a queue or a block", desc)
+	for store, pages := range pool.cache {
+		for num, page := range pages {
+			assert.True(t, used[page], "cached page is in used queue", num, desc)
+			assert.Equal(t, store, page.store, "store of cached page", num, desc)
+			assert.Equal(t, num, page.num, "num of cached page", num, desc)
+		}
+	}
+}
+
+func TestFaultyStore(t *testing.T) {
+	pageSize := MinPageSize
+	page := make([]byte, pageSize)
+	for i := range page {
+		page[i] = byte(i)
+	}
+	buf := make([]byte, pageSize)
+	zeros := make([]byte, pageSize)
+
+	store := NewFaultyStore(NewMemPageStore("test"))
+	assert.Nil(t, store.WritePage(1, page), "write page")
+	assert.Nil(t, store.ReadPage(1, buf), "read page")
+	assert.Equal(t, page, buf, "unsynced write is visible")
+	size, err := store.Size()
+	assert.Nil(t, err, "size")
+	assert.Equal(t, int64(2*pageSize), size, "unsynced write extends the store")
+	store.Crash()
+	assert.Nil(t, store.ReadPage(1, buf), "read page after crash")
+	assert.Equal(t, zeros, buf, "unsynced write is lost on crash")
+
+	assert.Nil(t, store.WritePage(1, page), "write page")
+	assert.Nil(t, store.Sync(), "sync")
+	store.Crash()
+	assert.Nil(t, store.ReadPage(1, buf), "read page after crash")
+	assert.Equal(t, page, buf, "synced write survives crash")
+
+	store.Inject(Fault{Op: OpSync, Kind: FaultSyncError, Count: 1})
+	assert.Nil(t, store.WritePage(2, page), "write page")
+	assert.Equal(t, syscall.EIO, store.Sync(), "sync fails")
+	store.Crash()
+	assert.Nil(t, store.ReadPage(2, buf), "read page after crash")
+	assert.Equal(t, zeros, buf, "write is lost when sync fails")
+
+	store.Inject(Fault{Op: OpWrite, Kind: FaultNoSpace, Pages: []TypePageNum{3}, After: 1, Count: 2})
+	assert.Nil(t, store.WritePage(2, page), "write to other page")
+	assert.Nil(t, store.WritePage(3, page), "first write to page is let through")
+	assert.Equal(t, syscall.ENOSPC, store.WritePage(3, page), "first failure")
+	assert.Equal(t, syscall.ENOSPC, store.WritePage(3, page), "second failure")
+	assert.Nil(t, store.WritePage(3, page), "fault is exhausted")
+	assert.Equal(t, 2, store.Failed(OpWrite), "failed writes")
+
+	store.Inject(Fault{Op: OpWrite, Kind: FaultShortWrite})
+	assert.Equal(t, io.ErrShortWrite, store.WritePage(4, page), "short write")
+	assert.Nil(t, store.ReadPage(4, buf), "read page")
+	assert.Equal(t, page[:pageSize/2], buf[:pageSize/2], "first half is written")
+	assert.Equal(t, zeros[pageSize/2:], buf[pageSize/2:], "second half is not written")
+	assert.Equal(t, io.ErrShortWrite, store.WritePage(4, page), "fault without count keeps failing")
+	store.ClearFaults()
+	assert.Nil(t, store.WritePage(4, page), "faults are cleared")
+
+	store.Inject(Fault{Op: OpRead, Kind: FaultShortRead, Count: 1})
+	assert.Equal(t, io.ErrUnexpectedEOF, store.ReadPage(1, buf), "short read")
+	assert.Equal(t, zeros[pageSize/2:], buf[pageSize/2:], "second half is not read")
+	assert.Nil(t, store.ReadPage(1, buf), "read page")
+	assert.Equal(t, page, buf, "content of page")
+	assert.Equal(t, 1, store.Failed(OpRead), "failed reads")
+}
+
+func TestPoolWithFaults(t *testing.T) {
+	testCases := []struct {
+		fault    Fault
+		action   func(fh *FileHandler) error
+		expected error
+		desc     string
+	}{
+		{
+			fault: Fault{Op: OpWrite, Kind: FaultNoSpace, Count: 1},
+			action: func(fh *FileHandler) error {
+				_, err := fh.GetThisPage(1)
+				return err
+			},
+			expected: syscall.ENOSPC,
+			desc:     "No space when evicting a dirty page",
+		},
+		{
+			fault: Fault{Op: OpWrite, Kind: FaultShortWrite, Count: 1},
+			action: func(fh *FileHandler) error {
+				_, err := fh.AllocatePage()
+				return err
+			},
+			expected: io.ErrShortWrite,
+			desc:     "Short write when evicting a dirty page",
+		},
+		{
+			fault:    Fault{Op: OpWrite, Kind: FaultNoSpace, After: 1, Count: 1},
+			action:   func(fh *FileHandler) error { return fh.ForcePages() },
+			expected: syscall.ENOSPC,
+			desc:     "Forcing pages fails partway",
+		},
+		{
+			fault:    Fault{Op: OpWrite, Kind: FaultShortWrite, After: 1, Count: 1},
+			action:   func(fh *FileHandler) error { return fh.Close() },
+			expected: io.ErrShortWrite,
+			desc:     "Releasing pages fails partway",
+		},
+		{
+			fault: Fault{Op: OpRead, Kind: FaultShortRead, Count: 1},
+			action: func(fh *FileHandler) error {
+				_, err := fh.GetThisPage(1)
+				return err
+			},
+			expected: io.ErrUnexpectedEOF,
+			desc:     "Short read when loading a page",
+		},
+	}
+
+	numFilePages := 5
+	for _, tc := range testCases {
+		// prepare: more dirty pages than frames
+		store := NewFaultyStore(NewMemPageStore("test"))
+		pool := NewBufferPool(3)
+		assert.Nil(t, pool.CreateStore(store, FileOptions{PageSize: MinPageSize}), "create store", tc.desc)
+		fh, err := pool.OpenStore(store)
+		assert.Nil(t, err, "open store", tc.desc)
+		for i := 1; i <= numFilePages; i++ {
+			page, err := fh.AllocatePage()
+			assert.Nil(t, err, "allocate page", i, tc.desc)
+			assert.Nil(t, page.WriteInt32(0, int32(i)), "write page", i, tc.desc)
+			assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page", i, tc.desc)
+		}
+		dirty := make(map[TypePageNum][]byte)
+		for num, page := range pool.cache[store] {
+			if page.dirty {
+				dirty[num] = append([]byte(nil), page.memBuffer.Bytes()...)
+			}
+		}
+		assert.NotEmpty(t, dirty, "some pages are dirty", tc.desc)
+
+		// test
+		store.Inject(tc.fault)
+		assert.ErrorIs(t, tc.action(fh), tc.expected, "error", tc.desc)
+		utilsCheckPoolLists(t, pool, tc.desc)
+		for num, content := range dirty {
+			if page, ok := pool.cache[store][num]; ok && page.dirty {
+				continue
+			}
+			buf := make([]byte, len(content))
+			assert.Nil(t, store.ReadPage(num, buf), "read page", num, tc.desc)
+			assert.Equal(t, content, buf, "page is either dirty or written", num, tc.desc)
+		}
+		for _, page := range pool.buffer {
+			assert.Equal(t, 0, page.pinned, "no page is pinned", page.idx, tc.desc)
+		}
+
+		// recover, and check that nothing is lost even after a crash
+		store.ClearFaults()
+		assert.Nil(t, fh.Close(), "close file", tc.desc)
+		utilsCheckPoolLists(t, pool, tc.desc)
+		assert.Nil(t, store.Sync(), "sync", tc.desc)
+		store.Crash()
+		fh, err = pool.OpenStore(store)
+		assert.Nil(t, err, "reopen store", tc.desc)
+		for i := 1; i <= numFilePages; i++ {
+			page, err := fh.GetThisPage(TypePageNum(i))
+			assert.Nil(t, err, "get page", i, tc.desc)
+			v, err := page.ReadInt32(0)
+			assert.Nil(t, err, "read page", i, tc.desc)
+			assert.Equal(t, int32(i), v, "content of page", i, tc.desc)
+			assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page", i, tc.desc)
+		}
+		assert.Nil(t, fh.Close(), "close file", tc.desc)
+	}
+}
+
+func TestFlushAllWithFaults(t *testing.T) {
+	pool := NewBufferPoolWithOptions(PoolOptions{NumPages: 8, SyncMode: SyncOnForce})
+	var stores []*FaultyStore
+	var handlers []*FileHandler
+	for i := 0; i < 2; i++ {
+		store := NewFaultyStore(NewMemPageStore(fmt.Sprintf("test%d", i)))
+		assert.Nil(t, pool.CreateStore(store, FileOptions{PageSize: MinPageSize}), "create store", i)
+		fh, err := pool.OpenStore(store)
+		assert.Nil(t, err, "open store", i)
+		for j := 1; j <= 3; j++ {
+			page, err := fh.AllocatePage()
+			assert.Nil(t, err, "allocate page", i, j)
+			assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page", i, j)
+		}
+		stores = append(stores, store)
+		handlers = append(handlers, fh)
+	}
+
+	stores[0].Inject(Fault{Op: OpWrite, Kind: FaultNoSpace, Pages: []TypePageNum{2}})
+	stores[1].Inject(Fault{Op: OpSync, Kind: FaultSyncError, Count: 1})
+	err := pool.FlushAll()
+	var flushErr *FlushError
+	assert.True(t, errors.As(err, &flushErr), "error is a FlushError")
+	assert.Equal(t, 2, len(flushErr.Errors), "failures")
+	failed := make(map[string]TypePageNum)
+	for _, e := range flushErr.Errors {
+		var pageErr *PageError
+		assert.True(t, errors.As(e, &pageErr), "failure is a PageError", e)
+		failed[pageErr.File] = pageErr.Page
+	}
+	assert.Equal(t, map[string]TypePageNum{"test0": 2, "test1": NonExistPageNum}, failed, "failed page and file")
+	assert.ErrorIs(t, err, syscall.ENOSPC, "write failure")
+	assert.ErrorIs(t, err, syscall.EIO, "sync failure")
+	for i, store := range pool.cache {
+		for num, page := range store {
+			assert.Equal(t, i == stores[0] && num == 2, page.dirty, "only the failed page is dirty", num)
+		}
+	}
+
+	err = pool.ForcePage(stores[0], 2)
+	assert.ErrorIs(t, err, syscall.ENOSPC, "force failing page")
+	assert.True(t, errors.As(err, &flushErr), "error of forcing is a FlushError")
+	assert.Equal(t, 1, len(flushErr.Errors), "the page fails, the file is synced")
+	stores[0].ClearFaults()
+	assert.Nil(t, pool.ForcePage(stores[0], 2), "force page")
+	assert.Nil(t, pool.FlushAll(), "nothing left to flush")
+	for _, fh := range handlers {
+		assert.Nil(t, fh.Close(), "close store")
+	}
+}
@@ -0,0 +1,177 @@
+package pagedfile
+
+import (
+	"sync"
+	"time"
+)
+
+// flusher is the background writer of a buffer pool.
+// It runs on a timer, or when woken up because too many frames are dirty, and writes dirty unpinned pages
+// without holding the pool latch, so that requests for pages are not held up by the writes.
+type flusher struct {
+	interval   time.Duration // time between two runs, no timer if 0
+	dirtyRatio float64       // ratio of dirty frames above which a run is started, disabled if 0
+	wake       chan struct{} // wakes up the writer, holds at most one pending request
+	stop       chan struct{} // closed to stop the writer
+	done       chan struct{} // closed when the writer has exited
+	stopOnce   sync.Once
+}
+
+func newFlusher(interval time.Duration, dirtyRatio float64) *flusher {
+	return &flusher{
+		interval:   interval,
+		dirtyRatio: dirtyRatio,
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Wakes up the writer if the ratio of dirty frames is above the threshold.
+// It is called with the pool latch held, and never blocks.
+func (f *flusher) dirtied(numDirty int, numFrames int) {
+	if f.dirtyRatio <= 0 || float64(numDirty) <= f.dirtyRatio*float64(numFrames) {
+		return
+	}
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (f *flusher) run(bp *BufferPool) {
+	defer close(f.done)
+	var tick <-chan time.Time
+	if f.interval > 0 {
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-tick:
+		case <-f.wake:
+		}
+		bp.flushDirtyPages(f.stop)
+	}
+}
+
+// Writes the pages which are dirty and unpinned, merging pages of the same file with consecutive page numbers.
+// Each run of pages is copied with the pool latch held, and written once it is released; files in mode `SyncEveryWrite`
+// are synced after the write. The pages stay dirty while they are written, so that they are written back as usual
+// if they are evicted meanwhile, and they are marked clean afterwards unless they have been pinned since they were copied.
+// Pages which fail to be written or synced stay dirty; it stops early if `stop` is closed.
+func (bp *BufferPool) flushDirtyPages(stop <-chan struct{}) {
+	type candidate struct {
+		page  *BufferedPage
+		store PageStore
+		num   TypePageNum
+	}
+	var runs [][]candidate
+	bp.latch.Lock()
+	var pages []*BufferedPage
+	for pos := bp.tailUsed; pos != nil; pos = pos.prev {
+		if pos.dirty && pos.pinned == 0 {
+			pages = append(pages, pos)
+		}
+	}
+	for _, run := range sortedRuns(pages) {
+		candidates := make([]candidate, len(run))
+		for i, page := range run {
+			candidates[i] = candidate{page, page.store, page.num}
+		}
+		runs = append(runs, candidates)
+	}
+	bp.latch.Unlock()
+
+	for _, run := range runs {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		bp.latch.Lock()
+		// the frames may have been reused, pinned or written since the candidates were collected
+		var valid []*BufferedPage
+		for _, c := range run {
+			if bp.cache[c.store][c.num] == c.page && c.page.dirty && c.page.pinned == 0 {
+				valid = append(valid, c.page)
+			}
+		}
+		if len(valid) == 0 {
+			bp.latch.Unlock()
+			continue
+		}
+		bp.flushRun(valid)
+	}
+}
+
+// Writes pages of the same file sorted by page number, for `flushDirtyPages`.
+// It is called with the pool latch held, which it releases while writing and syncing.
+func (bp *BufferPool) flushRun(pages []*BufferedPage) {
+	store, file := pages[0].store, pages[0].file
+	bufs := make([][]byte, len(pages))
+	pins := make([]uint64, len(pages))
+	for i, page := range pages {
+		page.prepareWrite()
+		bufs[i] = append([]byte(nil), page.memBuffer.Bytes()...)
+		pins[i] = page.pins
+	}
+	syncEvery := file.syncMode == SyncEveryWrite
+	// closing the file waits for the write, and write-backs of the same pages wait until it is done
+	file.syncing.Add(1)
+	bp.writeLatch.Lock()
+	bp.latch.Unlock()
+
+	// the pages are written in parts of consecutive page numbers, since some may have been left out
+	written := make([]bool, len(pages))
+	numWritten, attempted := 0, 0
+	for start := 0; start < len(pages); {
+		end := start + 1
+		for end < len(pages) && pages[end].num == pages[end-1].num+1 {
+			end += 1
+		}
+		n, err := store.WritePages(pages[start].num, bufs[start:end])
+		if err != nil && n >= end-start {
+			n = end - start - 1
+		}
+		for i := start; i < start+n; i++ {
+			written[i] = true
+		}
+		numWritten += n
+		if err != nil {
+			// the page it stopped at is left dirty, and the following pages are written again
+			n += 1
+		}
+		attempted += n
+		start += n
+	}
+	bp.writeLatch.Unlock()
+	// pages of a file which fails to be synced are not durable, and are written again later
+	synced := true
+	if numWritten > 0 && syncEvery {
+		synced = store.Sync() == nil
+	}
+	file.syncing.Done()
+
+	bp.latch.Lock()
+	defer bp.latch.Unlock()
+	bp.stats.DiskWrites += int64(attempted)
+	file.stats.DiskWrites += int64(attempted)
+	for i, page := range pages {
+		if !written[i] || !synced {
+			bp.stats.BackgroundErrors += 1
+			file.stats.BackgroundErrors += 1
+			continue
+		}
+		bp.stats.BackgroundWrites += 1
+		file.stats.BackgroundWrites += 1
+		// the page may have been written back and dropped, or changed since it was copied
+		if bp.cache[store][page.num] == page && page.dirty && page.pins == pins[i] {
+			page.dirty = false
+			bp.numDirty -= 1
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package pagedfile
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Waits until the condition holds, or fails the test after a second.
+func utilsWaitFor(t *testing.T, cond func() bool, desc ...interface{}) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			assert.Fail(t, "timed out", desc...)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBackgroundFlusher(t *testing.T) {
+	testCases := []struct {
+		opts PoolOptions
+		desc string
+	}{
+		{opts: PoolOptions{NumPages: 8, FlushInterval: 5 * time.Millisecond}, desc: "On interval"},
+		{opts: PoolOptions{NumPages: 8, FlushDirtyRatio: 0.25}, desc: "Above dirty ratio"},
+	}
+
+	for _, tc := range testCases {
+		// prepare: five dirty pages, one of which stays pinned
+		pool := NewBufferPoolWithOptions(tc.opts)
+		store := NewMemPageStore("test")
+		assert.Nil(t, pool.CreateStore(store, FileOptions{}), "create store", tc.desc)
+		fh, err := pool.OpenStore(store)
+		assert.Nil(t, err, "open store", tc.desc)
+		for i := 1; i <= 4; i++ {
+			page, err := fh.AllocatePage()
+			assert.Nil(t, err, "allocate page", i, tc.desc)
+			assert.Nil(t, page.WriteInt32(0, int32(i)), "write page", i, tc.desc)
+			assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page", i, tc.desc)
+		}
+		pinned, err := fh.AllocatePage()
+		assert.Nil(t, err, "allocate pinned page", tc.desc)
+
+		// test
+		utilsWaitFor(t, func() bool { return pool.Stats().DirtyFrames == 1 }, "unpinned pages are written", tc.desc)
+		stats := pool.Stats()
+		assert.Equal(t, 1, stats.PinnedFrames, "pinned frames", tc.desc)
+		assert.True(t, stats.BackgroundWrites >= 5, "background writes", tc.desc)
+		assert.Equal(t, int64(0), stats.BackgroundErrors, "background errors", tc.desc)
+		pool.latch.Lock()
+		assert.True(t, pool.cache[store][pinned.PageNum()].dirty, "pinned page is not written", tc.desc)
+		pool.latch.Unlock()
+		buf := make([]byte, PageSize)
+		for i := 1; i <= 4; i++ {
+			assert.Nil(t, store.ReadPage(TypePageNum(i), buf), "read page", i, tc.desc)
+			assert.Equal(t, byte(i), buf[pageHeaderSize+3], "content of written page", i, tc.desc)
+		}
+
+		assert.Nil(t, fh.UnpinPage(pinned.PageNum()), "unpin page", tc.desc)
+		assert.Nil(t, fh.Close(), "close store", tc.desc)
+		assert.Nil(t, pool.Close(), "close pool", tc.desc)
+		assert.Nil(t, pool.Close(), "close pool twice", tc.desc)
+	}
+}
+
+func TestBackgroundFlusherStopped(t *testing.T) {
+	pool := NewBufferPoolWithOptions(PoolOptions{NumPages: 4, FlushInterval: time.Millisecond})
+	assert.Nil(t, pool.Close(), "close pool")
+	store := NewMemPageStore("test")
+	assert.Nil(t, pool.CreateStore(store, FileOptions{}), "create store")
+	fh, err := pool.OpenStore(store)
+	assert.Nil(t, err, "open store")
+	page, err := fh.AllocatePage()
+	assert.Nil(t, err, "allocate page")
+	assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page")
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 2, pool.Stats().DirtyFrames, "nothing is written after the pool is closed")
+	assert.Nil(t, fh.Close(), "close store")
+
+	assert.Nil(t, NewBufferPool(4).Close(), "close pool without background writer")
+}
+
+func TestBackgroundFlusherSyncFailure(t *testing.T) {
+	store := NewFaultyStore(NewMemPageStore("test"))
+	pool := NewBufferPoolWithOptions(PoolOptions{NumPages: 8, SyncMode: SyncEveryWrite})
+	assert.Nil(t, pool.CreateStore(store, FileOptions{}), "create store")
+	fh, err := pool.OpenStore(store)
+	assert.Nil(t, err, "open store")
+	for i := 1; i <= 3; i++ {
+		page, err := fh.AllocatePage()
+		assert.Nil(t, err, "allocate page", i)
+		assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page", i)
+	}
+
+	// the header page and pages 1 to 3 are written in one run
+	store.Inject(Fault{Op: OpSync, Kind: FaultSyncError, Count: 1})
+	pool.flushDirtyPages(make(chan struct{}))
+	stats := pool.Stats()
+	assert.Equal(t, int64(0), stats.BackgroundWrites, "no page is durable")
+	assert.Equal(t, int64(4), stats.BackgroundErrors, "pages of failed sync")
+	assert.Equal(t, 4, stats.DirtyFrames, "pages of failed sync stay dirty")
+
+	pool.flushDirtyPages(make(chan struct{}))
+	stats = pool.Stats()
+	assert.Equal(t, int64(4), stats.BackgroundWrites, "pages are written again")
+	assert.Equal(t, 0, stats.DirtyFrames, "no dirty page")
+	assert.Equal(t, 1, store.Failed(OpSync), "failed syncs")
+	assert.Nil(t, fh.Close(), "close store")
+}
+
+// blockingWriteStore blocks the first write of pages after `block` is set, until `release` is closed.
+type blockingWriteStore struct {
+	PageStore
+	block   bool
+	writing chan struct{} // closed when the blocked write starts
+	release chan struct{}
+}
+
+func (s *blockingWriteStore) WritePages(num TypePageNum, bufs [][]byte) (int, error) {
+	if s.block {
+		s.block = false
+		close(s.writing)
+		<-s.release
+	}
+	return s.PageStore.WritePages(num, bufs)
+}
+
+func TestBackgroundFlusherWithoutLatch(t *testing.T) {
+	store := &blockingWriteStore{
+		PageStore: utilsNewMemFile(t, 4),
+		writing:   make(chan struct{}),
+		release:   make(chan struct{}),
+	}
+	pool := NewBufferPoolWithOptions(PoolOptions{NumPages: 8})
+	fh, err := pool.OpenStore(store)
+	assert.Nil(t, err, "open store")
+	page, err := fh.GetThisPage(1)
+	assert.Nil(t, err, "get page 1")
+	assert.Nil(t, page.WriteInt32(0, 7), "write page 1")
+	assert.Nil(t, fh.MarkDirty(1), "mark dirty")
+	assert.Nil(t, fh.UnpinPage(1), "unpin page 1")
+
+	// the write of page 1 blocks, while page 1 is requested and changed again
+	store.block = true
+	flushed := make(chan struct{})
+	go func() {
+		pool.flushDirtyPages(make(chan struct{}))
+		close(flushed)
+	}()
+	<-store.writing
+	hit := make(chan error)
+	go func() {
+		page, err := fh.GetThisPage(1)
+		if err == nil {
+			err = page.WriteInt32(0, 8)
+		}
+		hit <- err
+	}()
+	select {
+	case err = <-hit:
+		assert.Nil(t, err, "hit while writing")
+	case <-time.After(5 * time.Second):
+		t.Fatal("hit is blocked by background write")
+	}
+	assert.Nil(t, fh.MarkDirty(1), "mark dirty again")
+	assert.Nil(t, fh.UnpinPage(1), "unpin page 1 again")
+	close(store.release)
+	<-flushed
+
+	buf := make([]byte, PageSize)
+	assert.Nil(t, store.ReadPage(1, buf), "read page")
+	assert.Equal(t, byte(7), buf[pageHeaderSize+3], "content copied before the change is written")
+	pool.latch.Lock()
+	assert.True(t, pool.cache[store][1].dirty, "page changed while being written stays dirty")
+	pool.latch.Unlock()
+
+	pool.flushDirtyPages(make(chan struct{}))
+	assert.Nil(t, store.ReadPage(1, buf), "read page")
+	assert.Equal(t, byte(8), buf[pageHeaderSize+3], "changed content is written")
+	assert.Equal(t, 0, pool.Stats().DirtyFrames, "no dirty page")
+	assert.Nil(t, fh.Close(), "close store")
+}
@@ -0,0 +1,82 @@
+package pagedfile
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Maximum number of stack frames recorded for a pin.
+const maxPinDepth = 32
+
+// PinnedPage describes a page which is still pinned.
+type PinnedPage struct {
+	File   string      // name of the file
+	Page   TypePageNum // page number in the file
+	Pinned int         // number of references to the page
+	// Stacks of the goroutines which pinned the page since it was last completely unpinned, oldest first.
+	// Since unpinning does not tell which pin it drops, the leaking call site is one of them, not necessarily the last.
+	// It is empty if pin tracking is disabled.
+	Sites []string
+}
+
+func (p PinnedPage) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "page %d of file %s is pinned %d times", p.Page, p.File, p.Pinned)
+	for i, site := range p.Sites {
+		fmt.Fprintf(&b, "\npinned #%d at:\n%s", i+1, site)
+	}
+	return b.String()
+}
+
+// Records the stack of the goroutine pinning the page, starting at the caller of `clonePageHandle`.
+func (page *BufferedPage) recordPin() {
+	pcs := make([]uintptr, maxPinDepth)
+	n := runtime.Callers(3, pcs)
+	page.pinSites = append(page.pinSites, pcs[:n])
+}
+
+// Formats a recorded stack, one frame per line.
+func formatStack(pcs []uintptr) string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "\t%s\n\t\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
+
+// Returns every pinned page of the pool, ordered by file name and page number.
+// Call sites are recorded only if the pool was created with `TrackPins`.
+func (bp *BufferPool) LeakReport() []PinnedPage {
+	bp.latch.Lock()
+	defer bp.latch.Unlock()
+	return bp.pinnedPages(nil)
+}
+
+// Returns the pinned pages of given file, or of every file if store is nil.
+func (bp *BufferPool) pinnedPages(store PageStore) []PinnedPage {
+	ret := []PinnedPage{}
+	for pos := bp.headUsed; pos != nil; pos = pos.next {
+		if pos.pinned == 0 || (store != nil && pos.store != store) {
+			continue
+		}
+		p := PinnedPage{File: pos.file.name, Page: pos.num, Pinned: pos.pinned}
+		for _, pcs := range pos.pinSites {
+			p.Sites = append(p.Sites, formatStack(pcs))
+		}
+		ret = append(ret, p)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].File != ret[j].File {
+			return ret[i].File < ret[j].File
+		}
+		return ret[i].Page < ret[j].Page
+	})
+	return ret
+}
@@ -0,0 +1,70 @@
+package pagedfile
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func utilsLeakPage(t *testing.T, fh *FileHandler, num TypePageNum) {
+	_, err := fh.GetThisPage(num)
+	assert.Nil(t, err, "get page", num)
+}
+
+func TestLeakReport(t *testing.T) {
+	pool := NewBufferPoolWithOptions(PoolOptions{NumPages: 4, TrackPins: true})
+	fileName, fh := utilsOpenNewFile(t, pool)
+	for i := 1; i <= 3; i++ {
+		page, err := fh.AllocatePage()
+		assert.Nil(t, err, "allocate page", i)
+		assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page", i)
+	}
+	assert.Equal(t, []PinnedPage{}, pool.LeakReport(), "no pinned page")
+
+	utilsLeakPage(t, fh, 2)
+	_, err := fh.GetThisPage(2)
+	assert.Nil(t, err, "get page again")
+	_, err = fh.GetThisPage(3)
+	assert.Nil(t, err, "get page")
+	assert.Nil(t, fh.UnpinPage(3), "unpin page")
+
+	report := pool.LeakReport()
+	assert.Equal(t, 1, len(report), "one pinned page")
+	assert.Equal(t, fileName, report[0].File, "file of pinned page")
+	assert.Equal(t, TypePageNum(2), report[0].Page, "pinned page")
+	assert.Equal(t, 2, report[0].Pinned, "pin count")
+	assert.Equal(t, 2, len(report[0].Sites), "one site per pin")
+	assert.True(t, strings.Contains(report[0].Sites[0], "utilsLeakPage"), "first site is the leaking helper")
+	assert.False(t, strings.Contains(report[0].Sites[1], "utilsLeakPage"), "second site is the test itself")
+	assert.True(t, strings.Contains(report[0].Sites[1], "TestLeakReport"), "second site is the test itself")
+
+	err = fh.Close()
+	assert.True(t, errors.Is(err, ErrPageBeingUsed), "close with pinned page")
+	var pinned *ErrPagesPinned
+	assert.True(t, errors.As(err, &pinned), "close reports pinned pages")
+	assert.Equal(t, report, pinned.Pages, "pinned pages of the file")
+	assert.True(t, strings.Contains(err.Error(), "utilsLeakPage"), "message names the call site")
+
+	assert.Nil(t, fh.UnpinPage(2), "unpin page")
+	assert.Equal(t, 2, len(pool.LeakReport()[0].Sites), "sites are kept until the page is completely unpinned")
+	assert.Nil(t, fh.UnpinPage(2), "unpin page")
+	assert.Equal(t, []PinnedPage{}, pool.LeakReport(), "no pinned page after unpinning")
+	assert.Nil(t, fh.Close(), "close file")
+}
+
+func TestLeakReportUntracked(t *testing.T) {
+	pool := NewBufferPool(4)
+	_, fh := utilsOpenNewFile(t, pool)
+	page, err := fh.AllocatePage()
+	assert.Nil(t, err, "allocate page")
+
+	report := pool.LeakReport()
+	assert.Equal(t, 1, len(report), "one pinned page")
+	assert.Equal(t, page.PageNum(), report[0].Page, "pinned page")
+	assert.Nil(t, report[0].Sites, "no site without tracking")
+	assert.ErrorIs(t, fh.Close(), ErrPageBeingUsed, "close with pinned page")
+	assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page")
+	assert.Nil(t, fh.Close(), "close file")
+}
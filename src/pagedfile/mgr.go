@@ -1,11 +1,13 @@
 package pagedfile
 
 import (
-	"encoding/binary"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"os"
 	"strconv"
+	"sync"
+	"time"
 
 	"pkg/extio"
 )
@@ -13,15 +15,59 @@ import (
 type TypePageNum int // page's num of corresponding file
 type TypePoolIdx int // page's location in buffer pool
 
+// fileState holds bookkeeping shared by all buffered pages of the same file.
+type fileState struct {
+	name     string   // name of the file
+	stats    Stats    // counters of the file's pages
+	pageSize int      // size of the file's pages, at most the frame size of the pool
+	checksum bool     // whether pages carry a checksum in their trailer
+	syncMode SyncMode // when written pages are synced
+
+	frames    int // number of frames holding pages of the file
+	minFrames int // frames reserved for the file, 0 if none
+	maxFrames int // maximum number of frames the file may hold, 0 if unlimited
+
+	info    os.FileInfo  // identity of the file in the operating system, nil for other stores
+	handler *FileHandler // handle shared by everyone who opened the file, nil until it is opened
+	refs    int          // number of times the file is opened and not closed yet
+
+	lastNum     TypePageNum    // page requested last
+	seqRun      int            // number of consecutive requests for the page following the previous one
+	scanHint    bool           // whether the file is being scanned, so that pages are read ahead without detection
+	prefetchEnd TypePageNum    // pages before it have already been read ahead
+	prefetches  sync.WaitGroup // running read-ahead of the file
+	closing     bool           // whether the file is being closed, so that no read-ahead is started
+	syncing     sync.WaitGroup // syncs of the file running after write-backs, see `takeUnsynced`, and writes of the background writer
+}
+
+// Returns the number of bytes reserved at the end of each page.
+func (state *fileState) trailerSize() int {
+	if state.checksum {
+		return pageTrailerSize
+	}
+	return 0
+}
+
+// BufferedPage is a frame of the buffer pool.
+// Except for `latch` and `loadErr`, all fields are protected by the latch of the buffer pool.
 type BufferedPage struct {
-	memBuffer extio.BytesIO // internal memory manager, handles bytes data
-	idx       TypePoolIdx   // page's idx
-	num       TypePageNum   // page's num
-	next      *BufferedPage // next page in LRU queue
-	prev      *BufferedPage // prev page in LRU queue
-	dirty     bool          // whether there is un-flushed data in memory
-	pinned    int           // reference num of this page
-	fi        *os.File      // underlying file handler
+	frame      []byte        // memory of the frame, of which the first `pageSize` bytes hold the page
+	memBuffer  extio.BytesIO // internal memory manager, handles bytes data
+	idx        TypePoolIdx   // page's idx
+	num        TypePageNum   // page's num
+	next       *BufferedPage // next page in LRU queue
+	prev       *BufferedPage // prev page in LRU queue
+	dirty      bool          // whether there is un-flushed data in memory
+	pinned     int           // reference num of this page
+	store      PageStore     // underlying storage of the file
+	file       *fileState    // bookkeeping of the underlying file
+	loading    bool          // whether the page is being read ahead; it cannot be evicted meanwhile
+	prefetched bool          // whether the page was read ahead and has not been requested since
+	pinSites   [][]uintptr   // stacks of the pins since the page was last completely unpinned, if pins are tracked
+	pins       uint64        // number of pins ever taken on the frame, telling whether a page may have changed meanwhile
+
+	latch   sync.RWMutex // held exclusively while the page is being loaded from disk
+	loadErr error        // error of the last load, written with both latches held
 }
 
 func (page *BufferedPage) Print() {
@@ -37,89 +83,169 @@ func (page *BufferedPage) Print() {
 	fmt.Printf("Idx %d, prev %s, next %s.\n", page.idx, prevStr, nextStr)
 
 	fmt.Printf("Dirty: %t, Pinned: %d\n", page.dirty, page.pinned)
-	if page.fi == nil {
+	if page.store == nil {
 		fmt.Printf("File")
 	} else {
-		fmt.Printf("File: %s(%d), num: %d\n", page.fi.Name(), int(page.fi.Fd()), page.num)
+		fmt.Printf("File: %s, num: %d\n", page.store.Name(), page.num)
 	}
 	fmt.Println("----------------")
 }
 
 // Creates a page handle for a given buffered page.
 // It is allowed to create multiple page handles for the same page.
-func (page *BufferedPage) clonePageHandle() *PageHandle {
+// If `track` is true, the stack of the calling goroutine is recorded for `LeakReport`.
+func (page *BufferedPage) clonePageHandle(track bool) *PageHandle {
 	page.pinned += 1
+	page.pins += 1
+	if track {
+		page.recordPin()
+	}
 	return &PageHandle{
 		memBuffer: page.memBuffer,
 		num:       page.num,
+		tail:      page.file.trailerSize(),
 	}
 }
 
 // Set the page to a different file.
-func (page *BufferedPage) setNewFile(fi *os.File, num TypePageNum) {
-	page.fi = fi
+func (page *BufferedPage) setNewFile(store PageStore, file *fileState, num TypePageNum) {
+	page.store = store
+	page.file = file
 	page.num = num
 	page.pinned = 0
+	page.pinSites = nil
 	page.dirty = false
+	page.loadErr = nil
+	page.prefetched = false
+	page.memBuffer = extio.NewBasicBytesIO(page.frame[:file.pageSize])
 	page.memBuffer.Clear()
 }
 
-// Read data from on-disk file into in-memory buffer.
+// Read data from storage into in-memory buffer.
 func (page *BufferedPage) readFromDisk() error {
-	var err error
-	_, err = page.fi.Seek(int64(page.num*PageSize), io.SeekStart)
+	err := page.store.ReadPage(page.num, page.memBuffer.Bytes())
 	if err != nil {
-		return err
+		return page.wrapError("read", err)
 	}
-	_, err = page.memBuffer.Seek(int64(0), io.SeekStart)
-	if err != nil {
-		return err
+	if !page.file.checksum {
+		return nil
 	}
-	_, err = io.Copy(page.memBuffer, page.fi)
-	page.memBuffer.Seek(int64(0), io.SeekStart)
-	return err
-}
-
-// Write data from in-memory buffer to on-disk file.
-func (page *BufferedPage) writeToDisk() error {
-	var err error
-	_, err = page.fi.Seek(int64(page.num*PageSize), io.SeekStart)
+	valid, err := pageChecksumValid(page.store, page.num, page.memBuffer.Bytes())
 	if err != nil {
-		return err
+		return page.wrapError("read", err)
 	}
-	_, err = page.memBuffer.Seek(int64(0), io.SeekStart)
-	if err != nil {
-		return err
+	if !valid {
+		return page.wrapError("read", &ErrPageCorrupted{File: page.store.Name(), Page: page.num})
 	}
-	_, err = io.Copy(page.fi, page.memBuffer)
-	if err != nil {
-		return err
-	}
-	page.dirty = false
 	return nil
 }
 
+// Prepares the in-memory buffer to be written to storage, by setting its checksum if the file has checksums.
+func (page *BufferedPage) prepareWrite() {
+	if page.file.checksum {
+		setPageChecksum(page.memBuffer.Bytes())
+	}
+}
+
+// Wraps an error of an operation on the page into a `*PageError`.
+func (page *BufferedPage) wrapError(op string, err error) error {
+	return wrapPageError(op, page.store.Name(), page.num, err)
+}
+
+// BufferPool caches pages of files in a fixed number of frames. It is safe for concurrent use.
+//
+// The pool latch protects the page table, the LRU queues and the state of every frame.
+// Disk reads are performed without holding it; instead, a frame being loaded is pinned and its own latch
+// is held exclusively, so that other requests for the same page wait for the load while hits on other pages proceed.
+// Methods whose names start with a lowercase letter and do not take the latch expect the caller to hold it.
 type BufferPool struct {
-	cache    map[*os.File]map[TypePageNum]*BufferedPage // mapping from file and num to buffered page
-	buffer   []*BufferedPage                            // LRU queue's container
-	headUsed *BufferedPage                              // most recently used
-	tailUsed *BufferedPage                              // least recently used
-	headFree *BufferedPage                              // first unused page
+	latch    sync.Mutex
+	cache    map[PageStore]map[TypePageNum]*BufferedPage // mapping from file and num to buffered page
+	files    map[PageStore]*fileState                    // bookkeeping of files having pages in the pool
+	buffer   []*BufferedPage                             // LRU queue's container
+	headUsed *BufferedPage                               // most recently used
+	tailUsed *BufferedPage                               // least recently used
+	headFree *BufferedPage                               // first unused page
+	policy   ReplacementPolicy                           // chooses the page to evict among used pages
+	pageSize int                                         // size of every frame
+	stats    Stats                                       // counters of all pages, including those of closed files
+	numDirty int                                         // number of frames holding dirty pages
+	unsynced map[PageStore]*fileState                    // files written in mode `SyncEveryWrite` and not synced yet
+	flusher  *flusher                                    // background writer, nil if disabled
+
+	readAhead  int            // number of pages read ahead on sequential access, 0 if disabled
+	prefetches sync.WaitGroup // running read-ahead goroutines of every file
+	closed     bool           // whether the pool is closed, so that no read-ahead is started
+	available  chan struct{}  // closed when a frame may have become available, nil if nobody waits
+	trackPins  bool           // whether the call sites of pins are recorded
+	syncMode   SyncMode       // sync mode of files, unless set per file
+
+	blocks map[*byte]*BufferedPage // frames handed out as scratch blocks, by their first byte; in neither queue
+
+	openLatch  sync.Mutex // serializes opening and closing files; taken before the pool latch
+	writeLatch sync.Mutex // held while pages are written, so that writes of the same page are not reordered; taken after the pool latch
 }
 
-// Creates a buffer pool instance with given size
+// PoolOptions configures a buffer pool.
+type PoolOptions struct {
+	NumPages int               // number of pages the pool can hold
+	PageSize int               // size of every frame, `PageSize` if 0; files with larger pages cannot be opened
+	Policy   ReplacementPolicy // replacement policy, LRU if nil
+
+	// The background writer trickles dirty unpinned pages to disk at every `FlushInterval`,
+	// and whenever more than `FlushDirtyRatio` of the frames hold dirty pages. It is disabled if both are 0.
+	FlushInterval   time.Duration
+	FlushDirtyRatio float64
+
+	// Number of pages read ahead asynchronously when a file is accessed sequentially, disabled if 0.
+	// Files with a scan hint are read ahead even if it is 0, by `DefaultReadAhead` pages.
+	ReadAhead int
+
+	// Records the goroutine stack of every pin, so that pages left pinned can be traced back to where they were pinned,
+	// with `LeakReport` or the `ErrPagesPinned` error of closing a file. It slows down every pin, and is meant for debugging.
+	TrackPins bool
+
+	// Sync mode of the files opened in the pool, `SyncNone` by default. It can be changed per file with `SetSyncMode`.
+	SyncMode SyncMode
+}
+
+// Creates a buffer pool instance with given size, using LRU replacement.
 func NewBufferPool(numPages int) *BufferPool {
+	return NewBufferPoolWithOptions(PoolOptions{NumPages: numPages})
+}
+
+// Creates a buffer pool instance with given options.
+func NewBufferPoolWithOptions(opts PoolOptions) *BufferPool {
+	numPages := opts.NumPages
+	policy := opts.Policy
+	if policy == nil {
+		policy = NewLRUPolicy()
+	}
+	policy.Init(numPages)
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = PageSize
+	}
 	ret := &BufferPool{
-		cache:    make(map[*os.File]map[TypePageNum]*BufferedPage),
-		buffer:   make([]*BufferedPage, numPages),
-		headUsed: nil,
-		tailUsed: nil,
+		cache:     make(map[PageStore]map[TypePageNum]*BufferedPage),
+		files:     make(map[PageStore]*fileState),
+		blocks:    make(map[*byte]*BufferedPage),
+		buffer:    make([]*BufferedPage, numPages),
+		headUsed:  nil,
+		tailUsed:  nil,
+		policy:    policy,
+		pageSize:  pageSize,
+		readAhead: opts.ReadAhead,
+		trackPins: opts.TrackPins,
+		syncMode:  opts.SyncMode,
 	}
 
 	// Initialize LRU queue
 	for i := 0; i < numPages; i++ {
+		frame := make([]byte, pageSize)
 		ret.buffer[i] = &BufferedPage{
-			memBuffer: extio.NewBasicBytesIO(make([]byte, PageSize)),
+			frame:     frame,
+			memBuffer: extio.NewBasicBytesIO(frame),
 			idx:       TypePoolIdx(i),
 		}
 	}
@@ -137,52 +263,240 @@ func NewBufferPool(numPages int) *BufferPool {
 	}
 	ret.headFree = ret.buffer[0]
 
+	if opts.FlushInterval > 0 || opts.FlushDirtyRatio > 0 {
+		ret.flusher = newFlusher(opts.FlushInterval, opts.FlushDirtyRatio)
+		go ret.flusher.run(ret)
+	}
 	return ret
 }
 
-// Creates a new file with given filename.
+// FileOptions configures a file when it is created.
+type FileOptions struct {
+	PageSize int  // size of the file's pages, `PageSize` if 0
+	Checksum bool // store a CRC32C checksum in the trailer of every page and verify it when reading
+}
+
+// Returns the page size given by the options.
+// The page size must be a power of two between `MinPageSize` and `MaxPageSize`, otherwise error `ErrInvalidPageSize` is returned.
+func (opts FileOptions) pageSize() (int, error) {
+	if opts.PageSize == 0 {
+		return PageSize, nil
+	}
+	if !validPageSize(opts.PageSize) {
+		return 0, ErrInvalidPageSize
+	}
+	return opts.PageSize, nil
+}
+
+// Creates a new file with given filename and default options.
 // It will also write file header to the file.
 func (bp *BufferPool) CreateFile(fileName string) error {
+	return bp.CreateFileWithOptions(fileName, FileOptions{})
+}
+
+// Creates a new file with given filename and options.
+// It will also write the header page to the file.
+func (bp *BufferPool) CreateFileWithOptions(fileName string, opts FileOptions) error {
+	if _, err := opts.pageSize(); err != nil {
+		return wrapPageError("create", fileName, NonExistPageNum, err)
+	}
 	fi, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
 	if err != nil {
-		return err
+		return wrapPageError("create", fileName, NonExistPageNum, err)
 	}
-	defer fi.Close()
-	hdr := NewFileHeader()
-	err = binary.Write(fi, RWBytesOrder, hdr)
+	store := NewOSPageStore(fi)
+	defer store.Close()
+	return bp.CreateStore(store, opts)
+}
+
+// Initializes an empty page store as a paged file, by writing the header page to it.
+// If the store is not empty, error `ErrStoreNotEmpty` is returned.
+func (bp *BufferPool) CreateStore(store PageStore, opts FileOptions) error {
+	pageSize, err := opts.pageSize()
 	if err != nil {
-		return err
+		return wrapPageError("create", store.Name(), NonExistPageNum, err)
 	}
-	return nil
+	size, err := store.Size()
+	if err != nil {
+		return wrapPageError("create", store.Name(), NonExistPageNum, err)
+	}
+	if size != 0 {
+		return wrapPageError("create", store.Name(), NonExistPageNum, ErrStoreNotEmpty)
+	}
+	hdr := NewFileHeader()
+	hdr.PageSize = int32(pageSize)
+	if opts.Checksum {
+		hdr.Flags |= FlagChecksum
+	}
+	buf := make([]byte, pageSize)
+	err = (&FileHeaderMgr{hdr: hdr}).writeTo(extio.NewBasicBytesIO(buf))
+	if err == nil {
+		if opts.Checksum {
+			setPageChecksum(buf)
+		}
+		err = store.WritePage(FileHeaderPageNum, buf)
+	}
+	return wrapPageError("create", store.Name(), FileHeaderPageNum, err)
 }
 
 func (bp *BufferPool) DestroyFile(fileName string) error {
-	return os.Remove(fileName)
+	return wrapPageError("destroy", fileName, NonExistPageNum, os.Remove(fileName))
 }
 
 // Reads a new file with given filename.
 // It will first read the file header, obtaining all necessary information before returning the file handle.
+// If the file is already opened, its handle is shared: the same handle is returned, and it must be closed
+// as many times as it is opened. Files are identified by the operating system, not by their names.
 func (bp *BufferPool) OpenFile(fileName string) (*FileHandler, error) {
+	bp.openLatch.Lock()
+	defer bp.openLatch.Unlock()
 	fi, err := os.OpenFile(fileName, os.O_RDWR, 0600)
 	if err != nil {
-		return nil, err
+		return nil, wrapPageError("open", fileName, NonExistPageNum, err)
 	}
-	handler, err := NewFileHandler(fi, bp)
+	info, err := fi.Stat()
 	if err != nil {
 		fi.Close()
+		return nil, wrapPageError("open", fileName, NonExistPageNum, err)
+	}
+	if handler := bp.shareFile(func(state *fileState) bool {
+		return state.info != nil && os.SameFile(state.info, info)
+	}); handler != nil {
+		fi.Close()
+		return handler, nil
+	}
+	store := NewOSPageStore(fi)
+	handler, err := bp.openStore(store, info)
+	if err != nil {
+		store.Close()
 		return nil, err
 	}
 	return handler, nil
 }
 
+// Opens a paged file kept in given page store.
+// The store is closed together with the returned file handle, but not if opening fails.
+// Like `OpenFile`, opening a store which is already opened shares its handle.
+func (bp *BufferPool) OpenStore(store PageStore) (*FileHandler, error) {
+	bp.openLatch.Lock()
+	defer bp.openLatch.Unlock()
+	if handler := bp.shareFile(func(state *fileState) bool {
+		return state.handler != nil && state.handler.store == store
+	}); handler != nil {
+		return handler, nil
+	}
+	return bp.openStore(store, nil)
+}
+
+// Returns the handle of an opened file matching given condition, after counting one more reference to it.
+// If no opened file matches, `nil` is returned. The caller should hold `openLatch`.
+func (bp *BufferPool) shareFile(match func(state *fileState) bool) *FileHandler {
+	bp.latch.Lock()
+	defer bp.latch.Unlock()
+	for _, state := range bp.files {
+		if state.handler != nil && match(state) {
+			state.refs += 1
+			return state.handler
+		}
+	}
+	return nil
+}
+
+// Opens a paged file which is not opened yet. The caller should hold `openLatch`.
+func (bp *BufferPool) openStore(store PageStore, info os.FileInfo) (*FileHandler, error) {
+	handler, err := NewFileHandler(store, bp)
+	if err != nil {
+		bp.dropFile(store)
+		return nil, err
+	}
+	bp.latch.Lock()
+	state := bp.fileStateOf(store)
+	state.info = info
+	state.handler = handler
+	state.refs = 1
+	bp.latch.Unlock()
+	return handler, nil
+}
+
 // Closes a given file handle.
-// Before actually closes the file, it will first flush pages to disk.
+// If the handle is shared, only one reference to it is dropped. Otherwise, before actually closing the file,
+// it will first flush pages to disk; the handle cannot be used anymore after that.
 func (bp *BufferPool) CloseFile(fh *FileHandler) error {
-	err := bp.ReleasePages(fh.fi)
+	bp.openLatch.Lock()
+	defer bp.openLatch.Unlock()
+	bp.latch.Lock()
+	state := bp.fileStateOf(fh.store)
+	if state.refs > 1 {
+		state.refs -= 1
+		bp.latch.Unlock()
+		return nil
+	}
+	state.closing = true
+	bp.latch.Unlock()
+	state.prefetches.Wait()
+	err := bp.dropFile(fh.store)
 	if err != nil {
+		bp.latch.Lock()
+		state.closing = false
+		bp.latch.Unlock()
 		return err
 	}
-	return fh.fi.Close()
+	// the file has no page left to be written back, but syncs of earlier write-backs may still be running
+	state.syncing.Wait()
+	err = fh.store.Close()
+	if err != nil {
+		return wrapPageError("close", fh.store.Name(), NonExistPageNum, err)
+	}
+	fh.hdrMgr = nil
+	fh.bufPool = nil
+	fh.store = nil
+	return nil
+}
+
+// Flushes and drops all pages of a file from the pool, and forgets about the file.
+func (bp *BufferPool) dropFile(store PageStore) error {
+	err := bp.ReleasePages(store)
+	if err != nil {
+		return err
+	}
+	bp.latch.Lock()
+	delete(bp.files, store)
+	bp.latch.Unlock()
+	return nil
+}
+
+// Sets the page size and checksum option of a file, as read from its header.
+// If the page size is larger than the frames of the pool, error `ErrPageSizeMismatch` is returned.
+// Pages of the file cached with a different page size are dropped; they must be neither pinned nor dirty.
+func (bp *BufferPool) setFileOptions(store PageStore, pageSize int, checksum bool) error {
+	bp.latch.Lock()
+	defer bp.latch.Unlock()
+	if pageSize > bp.pageSize {
+		return wrapPageError("open", store.Name(), NonExistPageNum, ErrPageSizeMismatch)
+	}
+	state := bp.fileStateOf(store)
+	if state.pageSize != pageSize {
+		for _, page := range bp.cache[store] {
+			if page.pinned > 0 || page.dirty || page.loading {
+				return page.wrapError("open", ErrPageBeingUsed)
+			}
+			bp.evict(page)
+		}
+		state.pageSize = pageSize
+	}
+	state.checksum = checksum
+	return nil
+}
+
+// Returns the bookkeeping of a file, creating it on first use.
+// Until the header of the file has been read, its pages are as large as the frames.
+func (bp *BufferPool) fileStateOf(store PageStore) *fileState {
+	state, ok := bp.files[store]
+	if !ok {
+		state = &fileState{name: store.Name(), pageSize: bp.pageSize, syncMode: bp.syncMode}
+		bp.files[store] = state
+	}
+	return state
 }
 
 // Make a page the head of used LRU queue.
@@ -202,6 +516,7 @@ func (bp *BufferPool) makeHeadUsed(page *BufferedPage) {
 // Make a page the head of free queue.
 // Input argument `page` should not be already in the queue.
 func (bp *BufferPool) makeHeadFree(page *BufferedPage) {
+	bp.notifyAvailable()
 	page.next = bp.headFree
 	if bp.headFree != nil {
 		bp.headFree.prev = page
@@ -257,107 +572,280 @@ func (bp *BufferPool) moveToHeadUsed(page *BufferedPage) {
 
 // Load a free page, including inserting the page into used queue and put the page into map.
 func (bp *BufferPool) load(page *BufferedPage) {
-	if bp.cache[page.fi] == nil {
-		bp.cache[page.fi] = make(map[TypePageNum]*BufferedPage)
+	if bp.cache[page.store] == nil {
+		bp.cache[page.store] = make(map[TypePageNum]*BufferedPage)
 	}
-	bp.cache[page.fi][page.num] = page
+	bp.cache[page.store][page.num] = page
 	bp.removeFree(page)
 	bp.makeHeadUsed(page)
+	bp.policy.Loaded(page.idx)
+	page.file.frames += 1
 }
 
 // Evict a used page, including removing the page from used queue and remove it from map.
 func (bp *BufferPool) evict(page *BufferedPage) {
-	delete(bp.cache[page.fi], page.num)
-	if len(bp.cache[page.fi]) == 0 {
-		delete(bp.cache, page.fi)
+	if page.prefetched {
+		bp.stats.PrefetchWasted += 1
+		page.file.stats.PrefetchWasted += 1
+		page.prefetched = false
+	}
+	delete(bp.cache[page.store], page.num)
+	if len(bp.cache[page.store]) == 0 {
+		delete(bp.cache, page.store)
 	}
+	bp.unload(page)
+}
+
+// Returns the frame of a used page, which is no longer in the map, to the free queue.
+func (bp *BufferPool) unload(page *BufferedPage) {
 	bp.removeUsed(page)
 	bp.makeHeadFree(page)
+	bp.policy.Evicted(page.idx)
+	page.file.frames -= 1
 }
 
-// Find an available page.
-// If there is any free page, return the first of it.
-// Otherwise, find a page that is least frequently used, evict it, and returns that page.
+// Find an available page for a page of given file, or for a scratch block if file is nil.
+// If the file holds as many frames as its cap, one of its own unpinned pages is evicted, and returns that page;
+// if all of them are pinned, error `ErrFrameQuotaExceeded` is returned.
+// Otherwise, if there is any free page which is not reserved for other files, return the first of it.
+// Otherwise, ask the replacement policy for an unpinned page which is not reserved, evict it, and returns that page.
 // If no page is available (all pages have `pinned > 0`, then error `ErrNoAvailablePage` is returned.
 // Note that this function does not marked the returned page as in-use.
-func (bp *BufferPool) findAvailablePage() (*BufferedPage, error) {
-	if bp.headFree != nil {
-		return bp.headFree, nil
+func (bp *BufferPool) findAvailablePage(file *fileState) (*BufferedPage, error) {
+	if bp.atFrameCap(file) {
+		return bp.evictOverCap(file)
 	}
-	pos := bp.tailUsed
-	for pos != nil {
-		if pos.pinned == 0 {
-			break
-		}
-		pos = pos.prev
+	if page := bp.freeFrameFor(file); page != nil {
+		return page, nil
+	}
+	page, err := bp.evictVictim(func(page *BufferedPage) bool {
+		return bp.mayTakeFrame(file, page)
+	})
+	if errors.Is(err, ErrNoAvailablePage) {
+		bp.countReserveDenial(file)
 	}
-	if pos == nil {
+	return page, err
+}
+
+// Asks the replacement policy for an unpinned page accepted by `accept`, or any unpinned page if it is nil,
+// writes it back if it is dirty, and evicts it.
+// The frame of the evicted page is left at the head of the free queue.
+// If all pages are pinned or being loaded, error `ErrNoAvailablePage` is returned.
+func (bp *BufferPool) evictVictim(accept func(page *BufferedPage) bool) (*BufferedPage, error) {
+	idx, ok := bp.policy.Victim(func(idx TypePoolIdx) bool {
+		pos := bp.buffer[idx]
+		return pos.pinned == 0 && !pos.loading && (accept == nil || accept(pos))
+	})
+	if !ok {
 		return nil, ErrNoAvailablePage
 	}
+	pos := bp.buffer[idx]
 	if pos.dirty {
-		err := pos.writeToDisk()
+		err := bp.writeBack(pos)
 		if err != nil {
 			return nil, err
 		}
+		bp.stats.DirtyWriteBacks += 1
+		pos.file.stats.DirtyWriteBacks += 1
 	}
+	bp.stats.Evictions += 1
+	pos.file.stats.Evictions += 1
 	bp.evict(pos)
 	return pos, nil
 }
 
+// Writes a page to disk, counting the write. In mode `SyncEveryWrite`, the file is synced as well.
+func (bp *BufferPool) writeBack(page *BufferedPage) error {
+	if errs := bp.writeBackRun([]*BufferedPage{page}); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// Drops one reference of a page.
+// A page whose load failed is no longer in the map, and returns to the free queue once nobody references it.
+func (bp *BufferPool) unpin(page *BufferedPage) {
+	page.pinned -= 1
+	if page.pinned == 0 {
+		page.pinSites = nil
+		bp.notifyAvailable()
+	}
+	if page.pinned == 0 && page.loadErr != nil {
+		bp.unload(page)
+	}
+}
+
+// Returns a channel which is closed once a frame may have become available, by being freed or unpinned.
+func (bp *BufferPool) frameAvailable() <-chan struct{} {
+	bp.latch.Lock()
+	defer bp.latch.Unlock()
+	if bp.available == nil {
+		bp.available = make(chan struct{})
+	}
+	return bp.available
+}
+
+// Wakes up everyone waiting for a frame.
+func (bp *BufferPool) notifyAvailable() {
+	if bp.available != nil {
+		close(bp.available)
+		bp.available = nil
+	}
+}
+
+// Repeats an operation as long as it fails with error `ErrNoAvailablePage` or `ErrFrameQuotaExceeded`,
+// waiting for a frame to become available before every retry.
+// If ctx is done before the operation succeeds, the error of the context is returned.
+func (bp *BufferPool) waitForFrame(ctx context.Context, op func() error) error {
+	for {
+		available := bp.frameAvailable()
+		err := op()
+		if !errors.Is(err, ErrNoAvailablePage) && !errors.Is(err, ErrFrameQuotaExceeded) {
+			return err
+		}
+		select {
+		case <-available:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
 // Acquires a page for given file and corresponding page number, and returns a `PageHandle` instance.
-// If the page is already in cache, returns it directly.
+// If the page is already in cache, returns it directly, after waiting for any concurrent load of it.
 // Otherwise, it first calls `findAvailablePage` to find an available page for it and loads data on disk to memory.
-func (bp *BufferPool) getPage(file *os.File, num TypePageNum, unique bool) (*PageHandle, error) {
-	if page, ok := bp.cache[file][num]; ok { // already in LRU cache
+// Either way, the following pages may be read ahead in background.
+func (bp *BufferPool) getPage(store PageStore, num TypePageNum, unique bool) (*PageHandle, error) {
+	bp.latch.Lock()
+	if page, ok := bp.cache[store][num]; ok { // already in LRU cache
 		if page.pinned > 0 && unique {
-			return nil, ErrPageBeingUsed
+			bp.latch.Unlock()
+			return nil, page.wrapError("get", ErrPageBeingUsed)
 		}
 		bp.moveToHeadUsed(page)
-		return page.clonePageHandle(), nil
-	} else {
-		page, err := bp.findAvailablePage()
+		bp.stats.Hits += 1
+		page.file.stats.Hits += 1
+		if page.prefetched {
+			// the first request for a page read ahead is its first reference, not a second one
+			bp.policy.Evicted(page.idx)
+			bp.policy.Loaded(page.idx)
+			bp.stats.PrefetchHits += 1
+			page.file.stats.PrefetchHits += 1
+			page.prefetched = false
+		} else {
+			bp.policy.Accessed(page.idx)
+		}
+		bp.accessed(store, page.file, num)
+		handle := page.clonePageHandle(bp.trackPins)
+		bp.latch.Unlock()
+
+		page.latch.RLock()
+		err := page.loadErr
+		page.latch.RUnlock()
 		if err != nil {
+			bp.latch.Lock()
+			bp.unpin(page)
+			bp.latch.Unlock()
 			return nil, err
 		}
-		page.setNewFile(file, num)
+		return handle, nil
+	} else {
+		page, err := bp.findAvailablePage(bp.fileStateOf(store))
+		if err != nil {
+			bp.latch.Unlock()
+			return nil, wrapPageError("get", store.Name(), num, err)
+		}
+		page.setNewFile(store, bp.fileStateOf(store), num)
+		bp.stats.Misses += 1
+		bp.stats.DiskReads += 1
+		page.file.stats.Misses += 1
+		page.file.stats.DiskReads += 1
+		page.latch.Lock()
+		bp.load(page)
+		bp.accessed(store, page.file, num)
+		handle := page.clonePageHandle(bp.trackPins)
+		unsynced := bp.takeUnsynced()
+		bp.latch.Unlock()
+
 		err = page.readFromDisk()
+		syncErrs := syncWritten(unsynced)
 		if err != nil {
+			bp.latch.Lock()
+			page.loadErr = err
+			delete(bp.cache[store], num)
+			if len(bp.cache[store]) == 0 {
+				delete(bp.cache, store)
+			}
+			page.latch.Unlock()
+			bp.unpin(page)
+			bp.latch.Unlock()
 			return nil, err
 		}
-		bp.load(page)
-		return page.clonePageHandle(), nil
+		page.latch.Unlock()
+		if len(syncErrs) > 0 {
+			bp.latch.Lock()
+			bp.unpin(page)
+			bp.latch.Unlock()
+			return nil, syncErrs[0]
+		}
+		return handle, nil
 	}
 }
 
 // Allocates a new page for given file and page number.
 // If the page is already in cache, error `ErrPageAlreadyInBuffer` is returned.
-func (bp *BufferPool) allocatePage(file *os.File, num TypePageNum) (*PageHandle, error) {
-	if _, ok := bp.cache[file][num]; ok {
-		return nil, ErrPageAlreadyInBuffer
-	} else {
-		page, err := bp.findAvailablePage()
-		if err != nil {
-			return nil, err
-		}
-		page.setNewFile(file, num)
-		bp.load(page)
-		return page.clonePageHandle(), nil
+// If the file of an evicted page cannot be synced, the new page is dropped and the error is returned.
+func (bp *BufferPool) allocatePage(store PageStore, num TypePageNum) (*PageHandle, error) {
+	bp.latch.Lock()
+	if _, ok := bp.cache[store][num]; ok {
+		bp.latch.Unlock()
+		return nil, wrapPageError("allocate", store.Name(), num, ErrPageAlreadyInBuffer)
 	}
+	page, err := bp.findAvailablePage(bp.fileStateOf(store))
+	if err != nil {
+		bp.latch.Unlock()
+		return nil, wrapPageError("allocate", store.Name(), num, err)
+	}
+	page.setNewFile(store, bp.fileStateOf(store), num)
+	bp.load(page)
+	handle := page.clonePageHandle(bp.trackPins)
+	unsynced := bp.takeUnsynced()
+	bp.latch.Unlock()
+
+	if errs := syncWritten(unsynced); len(errs) > 0 {
+		bp.latch.Lock()
+		bp.unpin(page)
+		bp.evict(page)
+		bp.latch.Unlock()
+		return nil, errs[0]
+	}
+	return handle, nil
 }
 
 // Marks a page as dirty.
 // When a page is marked as dirty, BufferPool will flush the data to disk before evicting it from cache.
 // If the page is not in cache, error `ErrPageNotInBuffer` is returned.
 // If the page is not pinned(referenced), error `ErrPageNotInUse` is returned.
-func (bp *BufferPool) markDirty(file *os.File, num TypePageNum) error {
-	if page, ok := bp.cache[file][num]; !ok {
-		return ErrPageNotInBuffer
+func (bp *BufferPool) markDirty(store PageStore, num TypePageNum) error {
+	bp.latch.Lock()
+	defer bp.latch.Unlock()
+	if page, ok := bp.cache[store][num]; !ok {
+		return wrapPageError("mark dirty", store.Name(), num, ErrPageNotInBuffer)
 	} else {
 		if page.pinned == 0 {
-			return ErrPageNotInUse
+			return page.wrapError("mark dirty", ErrPageNotInUse)
 		} else {
-			page.dirty = true
+			if !page.dirty {
+				page.dirty = true
+				bp.numDirty += 1
+				if bp.flusher != nil {
+					bp.flusher.dirtied(bp.numDirty, len(bp.buffer))
+				}
+			}
 			bp.moveToHeadUsed(page)
+			if policy, ok := bp.policy.(RecencyPolicy); ok {
+				policy.Touched(page.idx)
+			}
 			return nil
 		}
 	}
@@ -366,49 +854,154 @@ func (bp *BufferPool) markDirty(file *os.File, num TypePageNum) error {
 // Unpins a page. It will decrease the page's reference counter by 1.
 // If the page is not in cache, error `ErrPageNotInBuffer` is returned.
 // If the page is not pinned(referenced), error `ErrPageNotInUse` is returned.
-func (bp *BufferPool) unpinPage(file *os.File, num TypePageNum) error {
-	if page, ok := bp.cache[file][num]; !ok {
-		return ErrPageNotInBuffer
+func (bp *BufferPool) unpinPage(store PageStore, num TypePageNum) error {
+	bp.latch.Lock()
+	defer bp.latch.Unlock()
+	if page, ok := bp.cache[store][num]; !ok {
+		return wrapPageError("unpin", store.Name(), num, ErrPageNotInBuffer)
 	} else {
 		if page.pinned == 0 {
-			return ErrPageNotInUse
+			return page.wrapError("unpin", ErrPageNotInUse)
 		} else {
-			page.pinned -= 1
+			bp.unpin(page)
 			return nil
 		}
 	}
 }
 
-// Releases all pages. It will flush all dirty pages of the file to disk.
-func (bp *BufferPool) ReleasePages(file *os.File) error {
-	for _, page := range bp.cache[file] {
-		if page.pinned > 0 {
-			return ErrPageBeingUsed
+// Releases all pages. It will flush all dirty pages of the file to disk, and sync the file as required by its sync mode,
+// without holding the pool latch. If a page cannot be written, a `*FlushError` is returned, and every page stays in cache.
+// If a page is pinned, error `ErrPageBeingUsed` is returned, caused by `*ErrPagesPinned` if the pool tracks pins.
+func (bp *BufferPool) ReleasePages(store PageStore) error {
+	bp.latch.Lock()
+	mode := bp.syncModeOf(store)
+	errs, err := bp.releasePages(store)
+	unsynced := bp.takeUnsynced()
+	bp.latch.Unlock()
+	if err != nil {
+		return err
+	}
+	errs = append(errs, syncWritten(unsynced)...)
+	if len(errs) > 0 {
+		return newFlushError(errs)
+	}
+	return syncForced(store, mode)
+}
+
+// Writes back the dirty pages of a file, and evicts all its pages if they are all written.
+// It returns the errors of the pages failing to be written, or an error if a page is pinned.
+func (bp *BufferPool) releasePages(store PageStore) ([]error, error) {
+	var dirty []*BufferedPage
+	for _, page := range bp.cache[store] {
+		if page.pinned > 0 && bp.trackPins {
+			return nil, wrapPageError("release", store.Name(), NonExistPageNum,
+				&ErrPagesPinned{File: page.file.name, Pages: bp.pinnedPages(store)})
+		}
+		if page.pinned > 0 || page.loading {
+			return nil, page.wrapError("release", ErrPageBeingUsed)
 		}
 		if page.dirty {
-			err := page.writeToDisk()
-			if err != nil {
-				return err
-			}
+			dirty = append(dirty, page)
 		}
+	}
+	if errs := bp.writeBackPages(dirty); len(errs) > 0 {
+		return errs, nil
+	}
+	for _, page := range bp.cache[store] {
 		bp.evict(page)
 	}
-	return nil
+	return nil, nil
 }
 
-func (bp *BufferPool) ForcePages(file *os.File) error {
-	for _, page := range bp.cache[file] {
-		if page.dirty {
-			err := page.writeToDisk()
-			if err != nil {
-				return err
+// Writes a single page of the file to disk if it is in cache and dirty.
+// The file is then synced as required by its sync mode, even if the page was clean,
+// since it may have been written back unsynced on eviction.
+// If writing or syncing fails, a `*FlushError` holding the failures is returned.
+func (bp *BufferPool) ForcePage(store PageStore, num TypePageNum) error {
+	var errs []error
+	bp.latch.Lock()
+	mode := bp.syncModeOf(store)
+	if page, ok := bp.cache[store][num]; ok && page.dirty {
+		if err := bp.writeBack(page); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	unsynced := bp.takeUnsynced()
+	bp.latch.Unlock()
+	errs = append(errs, syncWritten(unsynced)...)
+	if err := syncForced(store, mode); err != nil {
+		errs = append(errs, err)
+	}
+	return newFlushError(errs)
+}
+
+// Writes all dirty pages of the file to disk, keeping them in cache.
+// Unless the sync mode of the file is `SyncNone`, every page written so far is durable once it returns.
+// Pages failing to be written stay dirty, and the other pages are still written;
+// a `*FlushError` reports every page and sync that failed.
+func (bp *BufferPool) ForcePages(store PageStore) error {
+	return bp.forceFiles([]PageStore{store})
+}
+
+// Writes every dirty page of every open file to disk, keeping them in cache, and syncs the files as required
+// by their sync modes. Like `ForcePages`, it goes on after failures and reports them all in a `*FlushError`.
+// Files cannot be opened or closed meanwhile.
+func (bp *BufferPool) FlushAll() error {
+	bp.openLatch.Lock()
+	defer bp.openLatch.Unlock()
+	bp.latch.Lock()
+	stores := make([]PageStore, 0, len(bp.files))
+	for store := range bp.files {
+		stores = append(stores, store)
+	}
+	bp.latch.Unlock()
+	return bp.forceFiles(stores)
+}
+
+// Writes the dirty pages of given files, merging adjacent pages, then syncs the files without holding the pool latch.
+func (bp *BufferPool) forceFiles(stores []PageStore) error {
+	var errs []error
+	modes := make([]SyncMode, len(stores))
+	bp.latch.Lock()
+	var dirty []*BufferedPage
+	for i, store := range stores {
+		modes[i] = bp.syncModeOf(store)
+		for _, page := range bp.cache[store] {
+			if page.dirty {
+				dirty = append(dirty, page)
 			}
 		}
 	}
+	errs = bp.writeBackPages(dirty)
+	unsynced := bp.takeUnsynced()
+	bp.latch.Unlock()
+	errs = append(errs, syncWritten(unsynced)...)
+	for i, store := range stores {
+		if err := syncForced(store, modes[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return newFlushError(errs)
+}
+
+// Stops the background workers of the pool, and waits for them to exit: the background writer, if any,
+// and the running read-ahead; no read-ahead is started afterwards. Opened files are neither flushed nor closed.
+// It is safe to call it more than once.
+func (bp *BufferPool) Close() error {
+	if f := bp.flusher; f != nil {
+		f.stopOnce.Do(func() { close(f.stop) })
+		<-f.done
+	}
+	bp.latch.Lock()
+	bp.closed = true
+	bp.latch.Unlock()
+	bp.prefetches.Wait()
 	return nil
 }
 
 func (bp *BufferPool) Print() {
+	bp.latch.Lock()
+	defer bp.latch.Unlock()
 	nUsed := 0
 	fmt.Println("Used list: ")
 	pos := bp.headUsed
@@ -1,13 +1,19 @@
 package pagedfile
 
 import (
+	"expvar"
 	"fmt"
-	"os"
+	"math/rand"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// Number of pools published as expvar variables by the tests.
+var publishedPools int32
+
 type LinkedListType int
 
 const (
@@ -36,7 +42,7 @@ func TestNewBufferPool(t *testing.T) {
 		assert.Equal(t, TypePoolIdx(i), pool.buffer[i].idx, "Index of page", i)
 		assert.Equal(t, false, pool.buffer[i].dirty, "Page not dirty", i)
 		assert.Equal(t, 0, pool.buffer[i].pinned, "Pinned of page", i)
-		assert.Nil(t, pool.buffer[i].fi, "Not file set for page", i)
+		assert.Nil(t, pool.buffer[i].store, "Not file set for page", i)
 	}
 }
 
@@ -295,17 +301,21 @@ func TestFindAvaiablePage(t *testing.T) {
 		utilsMakeLinkedList(pool, tc.originalFree, FreeList)
 
 		for i, idx := range tc.originalUsed {
-			pool.buffer[idx].fi = &os.File{}
+			pool.buffer[idx].store = NewMemPageStore("test")
+			pool.buffer[idx].file = &fileState{}
 			pool.buffer[idx].num = 0
 			pool.buffer[idx].pinned = tc.pinned[i]
 		}
+		for i := len(tc.originalUsed) - 1; i >= 0; i-- {
+			pool.policy.Loaded(tc.originalUsed[i])
+		}
 
 		// test
-		page, err := pool.findAvailablePage()
+		page, err := pool.findAvailablePage(nil)
 		assert.Equal(t, tc.err, err, "error", tc.desc)
 		if tc.err == nil {
 			assert.Equal(t, tc.expectedIdx, page.idx, "returned index", tc.desc)
-			_, ok := pool.cache[page.fi][page.num]
+			_, ok := pool.cache[page.store][page.num]
 			assert.Equal(t, false, ok, "returned page is no longer in cache")
 
 			if !tc.evicted {
@@ -327,3 +337,128 @@ func TestFindAvaiablePage(t *testing.T) {
 		}
 	}
 }
+
+func TestConcurrentGetPage(t *testing.T) {
+	numFilePages := 32
+	pool := NewBufferPool(4)
+	fileName, fh := utilsOpenNewFile(t, pool)
+	for i := 1; i <= numFilePages; i++ {
+		page, err := fh.AllocatePage()
+		assert.Nil(t, err, "allocate page", i)
+		assert.Nil(t, page.WriteInt32(0, int32(i)), "write page num", i)
+		assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page", i)
+	}
+	assert.Nil(t, fh.Close(), "close file")
+
+	numWorkers := 6
+	pool = NewBufferPool(8)
+	fh, err := pool.OpenFile(fileName)
+	assert.Nil(t, err, "reopen file")
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < 500; i++ {
+				num := TypePageNum(1 + r.Intn(numFilePages))
+				page, err := pool.getPage(fh.store, num, false)
+				if !assert.Nil(t, err, "get page", num) {
+					return
+				}
+				v, err := page.ReadInt32(0)
+				assert.Nil(t, err, "read page num")
+				assert.Equal(t, int32(num), v, "page content")
+				assert.Nil(t, pool.unpinPage(fh.store, num), "unpin page", num)
+			}
+		}(int64(w))
+	}
+	wg.Wait()
+
+	for _, page := range pool.buffer {
+		assert.Equal(t, 0, page.pinned, "no page is pinned", page.idx)
+	}
+	assert.Nil(t, fh.Close(), "close file")
+}
+
+func TestConcurrentAllocatePage(t *testing.T) {
+	numWorkers := 4
+	numAllocs := 20
+	pool := NewBufferPool(numWorkers + 1)
+	_, fh := utilsOpenNewFile(t, pool)
+
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < numAllocs; i++ {
+				page, err := fh.AllocatePage()
+				if !assert.Nil(t, err, "allocate page") {
+					return
+				}
+				assert.Nil(t, page.WriteInt32(0, int32(page.PageNum())), "write page num")
+				assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page")
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(numWorkers*numAllocs+1), fh.hdrMgr.hdr.NumPages, "num pages")
+	assert.Nil(t, fh.Close(), "close file")
+}
+
+func TestPoolStats(t *testing.T) {
+	pool := NewBufferPool(4)
+	fileName, fh := utilsOpenNewFile(t, pool)
+	for i := 1; i <= 3; i++ {
+		page, err := fh.AllocatePage()
+		assert.Nil(t, err, "allocate page", i)
+		assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page", i)
+	}
+	assert.Nil(t, fh.Close(), "close file")
+
+	pool = NewBufferPool(2)
+	fh, err := pool.OpenFile(fileName)
+	assert.Nil(t, err, "reopen file")
+	for i := 0; i < 2; i++ {
+		_, err = fh.GetThisPage(1)
+		assert.Nil(t, err, "get page 1")
+	}
+	assert.Nil(t, fh.MarkDirty(1), "mark dirty")
+	stats := pool.Stats()
+	assert.Equal(t, 1, stats.PinnedFrames, "pinned frames")
+	assert.Equal(t, 1, stats.DirtyFrames, "dirty frames")
+	assert.Equal(t, 0, stats.FreeFrames, "free frames")
+	assert.Equal(t, 1, stats.Files[fileName].PinnedFrames, "pinned frames of file")
+	assert.Nil(t, fh.UnpinPage(1), "unpin page 1")
+	assert.Nil(t, fh.UnpinPage(1), "unpin page 1")
+
+	// evicts the header page, then the dirty page 1
+	for _, num := range []TypePageNum{2, 3} {
+		_, err = fh.GetThisPage(num)
+		assert.Nil(t, err, "get page", num)
+		assert.Nil(t, fh.UnpinPage(num), "unpin page", num)
+	}
+
+	expected := Stats{
+		Hits:            2,
+		Misses:          4,
+		Evictions:       2,
+		DirtyWriteBacks: 1,
+		DiskReads:       4,
+		DiskWrites:      1,
+		UsedFrames:      2,
+	}
+	stats = pool.Stats()
+	assert.Equal(t, expected, stats.Stats, "pool stats")
+	assert.Equal(t, 2, stats.NumFrames, "num frames")
+	assert.Equal(t, map[string]Stats{fileName: expected}, stats.Files, "file stats")
+
+	// expvar names cannot be reused, and the test may run several times in the same process
+	name := fmt.Sprintf("%s_%d", t.Name(), atomic.AddInt32(&publishedPools, 1))
+	pool.Publish(name)
+	assert.Contains(t, expvar.Get(name).String(), `"DirtyWriteBacks":1`, "expvar")
+	assert.Nil(t, fh.Close(), "close file")
+	assert.Equal(t, 0, len(pool.Stats().Files), "closed files are not listed")
+}
@@ -1,8 +1,136 @@
 package pagedfile
 
-import "pkg/extio"
+import (
+	"math"
 
+	"pkg/extio"
+)
+
+const (
+	pageHeaderSize = 4 // size of the PageHeader in bytes
+)
+
+// PageHeader lies at the beginning of every data page.
+// It links disposed pages together into the file's free list.
+type PageHeader struct {
+	NextFree int32 // Next page in the free list, or `InUsePageNum` if the page is allocated.
+}
+
+// PageHandle refers to a pinned page in the buffer pool.
+// Offsets accepted by the read and write helpers are relative to the data area returned by `Data`,
+// and values are encoded with `RWBytesOrder`.
 type PageHandle struct {
 	memBuffer extio.BytesIO
 	num       TypePageNum
+	tail      int // bytes reserved at the end of the page
+}
+
+// Returns the `NextFree` field of the page header.
+func (ph *PageHandle) nextFree() (TypePageNum, error) {
+	buf := make([]byte, pageHeaderSize)
+	_, err := ph.memBuffer.ReadAt(buf, 0)
+	if err != nil {
+		return NonExistPageNum, err
+	}
+	return TypePageNum(int32(RWBytesOrder.Uint32(buf))), nil
+}
+
+// Sets the `NextFree` field of the page header.
+func (ph *PageHandle) setNextFree(num TypePageNum) error {
+	buf := make([]byte, pageHeaderSize)
+	RWBytesOrder.PutUint32(buf, uint32(int32(num)))
+	_, err := ph.memBuffer.WriteAt(buf, 0)
+	return err
+}
+
+// Returns the page number of the page in its file.
+func (ph *PageHandle) PageNum() TypePageNum {
+	return ph.num
+}
+
+// Returns the data area of the page, i.e., the buffered page without its page header and trailer.
+// The returned slice shares memory with the buffer pool, and is only valid while the page is pinned.
+// Remember to mark the page as dirty after modifying it.
+func (ph *PageHandle) Data() []byte {
+	buf := ph.memBuffer.Bytes()
+	return buf[pageHeaderSize : len(buf)-ph.tail]
+}
+
+// Returns the `size` bytes at `offset` of the data area.
+func (ph *PageHandle) field(offset int, size int) ([]byte, error) {
+	data := ph.Data()
+	if offset < 0 || size > len(data) || offset > len(data)-size {
+		return nil, ErrInvalidOffset
+	}
+	return data[offset : offset+size], nil
+}
+
+func (ph *PageHandle) ReadInt32(offset int) (int32, error) {
+	v, err := ph.ReadUint32(offset)
+	return int32(v), err
+}
+
+func (ph *PageHandle) WriteInt32(offset int, v int32) error {
+	return ph.WriteUint32(offset, uint32(v))
+}
+
+func (ph *PageHandle) ReadInt64(offset int) (int64, error) {
+	v, err := ph.ReadUint64(offset)
+	return int64(v), err
+}
+
+func (ph *PageHandle) WriteInt64(offset int, v int64) error {
+	return ph.WriteUint64(offset, uint64(v))
+}
+
+func (ph *PageHandle) ReadUint32(offset int) (uint32, error) {
+	buf, err := ph.field(offset, 4)
+	if err != nil {
+		return 0, err
+	}
+	return RWBytesOrder.Uint32(buf), nil
+}
+
+func (ph *PageHandle) WriteUint32(offset int, v uint32) error {
+	buf, err := ph.field(offset, 4)
+	if err != nil {
+		return err
+	}
+	RWBytesOrder.PutUint32(buf, v)
+	return nil
+}
+
+func (ph *PageHandle) ReadUint64(offset int) (uint64, error) {
+	buf, err := ph.field(offset, 8)
+	if err != nil {
+		return 0, err
+	}
+	return RWBytesOrder.Uint64(buf), nil
+}
+
+func (ph *PageHandle) WriteUint64(offset int, v uint64) error {
+	buf, err := ph.field(offset, 8)
+	if err != nil {
+		return err
+	}
+	RWBytesOrder.PutUint64(buf, v)
+	return nil
+}
+
+func (ph *PageHandle) ReadFloat32(offset int) (float32, error) {
+	v, err := ph.ReadUint32(offset)
+	return math.Float32frombits(v), err
+}
+
+func (ph *PageHandle) WriteFloat32(offset int, v float32) error {
+	return ph.WriteUint32(offset, math.Float32bits(v))
+}
+
+func (ph *PageHandle) ReadFloat64(offset int) (float64, error) {
+	v, err := ph.ReadUint64(offset)
+	return math.Float64frombits(v), err
+}
+
+func (ph *PageHandle) WriteFloat64(offset int, v float64) error {
+	return ph.WriteUint64(offset, math.Float64bits(v))
 }
@@ -1,77 +1,501 @@
 package pagedfile
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
-	"os"
 	"pkg/extio"
+	"sync"
 )
 
 const (
 	NonExistPageNum   = -1
 	FileHeaderPageNum = 0
+	InUsePageNum      = -2 // `NextFree` of a page that is not in the free list
+)
+
+const (
+	FlagChecksum int32 = 1 << iota // pages carry a CRC32C checksum in their trailer
+
+	knownFlags = FlagChecksum
+)
+
+const (
+	FileMagic           uint32 = 0x52425046 // "RBPF", identifies paged files
+	FileFormatVersion   int32  = 1          // version of the file format written by this package
+	LegacyFormatVersion int32  = 0          // version of headers written before the magic number, see `NewFileHeaderMgr`
 )
 
 // FileHeader always lies on the first page of a file, providing necessary page information.
+// Disposed pages form a linked list starting at `FirstFreePage`, chained through their page headers.
+// `Magic` and `Version` keep their positions in every version of the format.
 type FileHeader struct {
-	FirstFreePage int32 // Page number of a file's first free page.
-	NumPages      int32 // Number of pages (including header page)
+	Magic         uint32 // Always `FileMagic`
+	Version       int32  // Format version of the file
+	PageSize      int32  // Size of every page in bytes
+	Flags         int32  // Options of the file, see `FlagChecksum`
+	FirstFreePage int32  // Page number of a file's first free page.
+	NumPages      int32  // Number of pages (including header page)
 }
 
 func NewFileHeader() *FileHeader {
 	return &FileHeader{
+		Magic:         FileMagic,
+		Version:       FileFormatVersion,
+		PageSize:      PageSize,
 		FirstFreePage: NonExistPageNum,
 		NumPages:      1,
 	}
 }
 
+// HeaderMigration upgrades the header page of a file from one format version to the next one, in place.
+// It must also update the version stored in the page.
+type HeaderMigration func(page []byte) error
+
+var headerMigrations = map[int32]HeaderMigration{
+	LegacyFormatVersion: migrateLegacyHeader,
+}
+
+// Upgrades a legacy header to version 1, which adds the magic number, the version, the page size and the flags
+// in front of the first free page and the number of pages. Legacy files always use the default page size.
+func migrateLegacyHeader(page []byte) error {
+	firstFree := RWBytesOrder.Uint32(page[0:4])
+	numPages := RWBytesOrder.Uint32(page[4:8])
+	RWBytesOrder.PutUint32(page[0:4], FileMagic)
+	RWBytesOrder.PutUint32(page[4:8], 1)
+	RWBytesOrder.PutUint32(page[8:12], PageSize)
+	RWBytesOrder.PutUint32(page[12:16], 0)
+	RWBytesOrder.PutUint32(page[16:20], firstFree)
+	RWBytesOrder.PutUint32(page[20:24], numPages)
+	return nil
+}
+
+// Registers the migration from format version `from` to `from+1`.
+// Files of older versions are upgraded when they are opened, and rejected if a migration is missing.
+// The migration from `LegacyFormatVersion` is built in and must write the magic number as well.
+// It is not safe to call this function while files are being opened.
+func RegisterHeaderMigration(from int32, migration HeaderMigration) {
+	headerMigrations[from] = migration
+}
+
+// FileHeaderMgr keeps an in-memory copy of a file's header.
+// The header page itself is only pinned while the header is being read or written.
 type FileHeaderMgr struct {
-	hdr *FileHeader
-	io  extio.BytesIO
+	hdr      *FileHeader
+	upgraded bool // whether the header has been migrated from an older version and has to be written back
 }
 
+// Returns whether a page without magic number holds a legacy header, which only consists of `FirstFreePage`
+// and `NumPages` at the start of the page.
+func isLegacyHeader(page []byte) bool {
+	firstFree := int32(RWBytesOrder.Uint32(page[0:4]))
+	numPages := int32(RWBytesOrder.Uint32(page[4:8]))
+	return numPages >= 1 &&
+		(firstFree == NonExistPageNum || (firstFree > FileHeaderPageNum && firstFree < numPages))
+}
+
+// Reads and validates the header from a header page.
+// Headers of older format versions are upgraded with registered migrations; the page itself is left untouched.
+// A header without magic number is a legacy header of version `LegacyFormatVersion` if it looks like one,
+// and error `ErrNotPagedFile` is returned otherwise.
 func NewFileHeaderMgr(io extio.BytesIO) (*FileHeaderMgr, error) {
+	page := make([]byte, len(io.Bytes()))
+	copy(page, io.Bytes())
+	var version int32
+	if RWBytesOrder.Uint32(page[0:4]) == FileMagic {
+		version = int32(RWBytesOrder.Uint32(page[4:8]))
+		if version <= LegacyFormatVersion {
+			return nil, ErrBadFileHeader
+		}
+	} else if isLegacyHeader(page) {
+		version = LegacyFormatVersion
+	} else {
+		return nil, ErrNotPagedFile
+	}
+	upgraded := false
+	for version < FileFormatVersion {
+		migration, ok := headerMigrations[version]
+		if !ok {
+			return nil, ErrUnsupportedVersion
+		}
+		err := migration(page)
+		if err != nil {
+			return nil, err
+		}
+		next := int32(RWBytesOrder.Uint32(page[4:8]))
+		if RWBytesOrder.Uint32(page[0:4]) != FileMagic || next != version+1 {
+			return nil, ErrBadFileHeader
+		}
+		version = next
+		upgraded = true
+	}
+	if version > FileFormatVersion {
+		return nil, ErrUnsupportedVersion
+	}
+
 	hdr := &FileHeader{}
-	err := binary.Read(io, RWBytesOrder, hdr)
+	err := binary.Read(bytes.NewReader(page), RWBytesOrder, hdr)
 	if err != nil {
 		return nil, err
 	}
+	if !validPageSize(int(hdr.PageSize)) || hdr.Flags&^knownFlags != 0 || hdr.NumPages < 1 ||
+		(hdr.FirstFreePage != NonExistPageNum && (hdr.FirstFreePage <= FileHeaderPageNum || hdr.FirstFreePage >= hdr.NumPages)) {
+		return nil, ErrBadFileHeader
+	}
 	return &FileHeaderMgr{
-		hdr: hdr,
-		io:  io,
+		hdr:      hdr,
+		upgraded: upgraded,
 	}, nil
 }
 
+// Encodes the in-memory header into the beginning of the given buffer.
+func (mgr *FileHeaderMgr) writeTo(io extio.BytesIO) error {
+	buf := new(bytes.Buffer)
+	err := binary.Write(buf, RWBytesOrder, mgr.hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteAt(buf.Bytes(), 0)
+	return err
+}
+
+// FileHandler provides page access to an opened file. It is safe for concurrent use.
 type FileHandler struct {
-	hdrMgr *FileHeaderMgr
+	hdrMgr   *FileHeaderMgr
+	hdrLatch sync.RWMutex // protects the in-memory header; held exclusively while allocating or disposing pages
 
 	bufPool *BufferPool
-	fi      *os.File
+	store   PageStore
 }
 
-func NewFileHandler(fi *os.File, pool *BufferPool) (*FileHandler, error) {
-	page, err := pool.getPage(fi, FileHeaderPageNum, false)
+// Reads the header of a file. The header page is read with the frame size of the pool,
+// which may not be the page size of the file, so the page is only used to find out the header.
+func readFileHeader(store PageStore, pool *BufferPool) (*FileHeaderMgr, error) {
+	page, err := pool.getPage(store, FileHeaderPageNum, false)
 	if err != nil {
 		return nil, err
 	}
+	defer pool.unpinPage(store, FileHeaderPageNum)
 	hdrMgr, err := NewFileHeaderMgr(page.memBuffer)
+	if err != nil {
+		return nil, wrapPageError("open", store.Name(), FileHeaderPageNum, err)
+	}
+	return hdrMgr, nil
+}
+
+func NewFileHandler(store PageStore, pool *BufferPool) (*FileHandler, error) {
+	hdrMgr, err := readFileHeader(store, pool)
 	if err != nil {
 		return nil, err
 	}
+	checksum := hdrMgr.hdr.Flags&FlagChecksum != 0
+	err = pool.setFileOptions(store, int(hdrMgr.hdr.PageSize), checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := pool.getPage(store, FileHeaderPageNum, false)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.unpinPage(store, FileHeaderPageNum)
+	// The header page is read before the flags are known, so its checksum is verified here.
+	if checksum {
+		valid, err := pageChecksumValid(store, FileHeaderPageNum, page.memBuffer.Bytes())
+		if err == nil && !valid {
+			err = &ErrPageCorrupted{File: store.Name(), Page: FileHeaderPageNum}
+		}
+		if err != nil {
+			return nil, wrapPageError("open", store.Name(), FileHeaderPageNum, err)
+		}
+	}
+	if hdrMgr.upgraded {
+		err = hdrMgr.writeTo(page.memBuffer)
+		if err == nil {
+			err = pool.markDirty(store, FileHeaderPageNum)
+		}
+		if err != nil {
+			return nil, wrapPageError("open", store.Name(), FileHeaderPageNum, err)
+		}
+		hdrMgr.upgraded = false
+	}
 	return &FileHandler{
 		hdrMgr:  hdrMgr,
 		bufPool: pool,
-		fi:      fi,
+		store:   store,
 	}, nil
+}
 
+// Returns the size of the file's pages.
+func (fh *FileHandler) PageSize() int {
+	return int(fh.hdrMgr.hdr.PageSize)
 }
 
-func (fh *FileHandler) Close() error {
-	err := fh.bufPool.CloseFile(fh)
+// Returns the number of pages of the file, including the header page.
+func (fh *FileHandler) numPages() TypePageNum {
+	fh.hdrLatch.RLock()
+	defer fh.hdrLatch.RUnlock()
+	return TypePageNum(fh.hdrMgr.hdr.NumPages)
+}
+
+// Wraps an error of an operation on a page of the file into a `*PageError`, see `withPageOp`.
+func (fh *FileHandler) wrapError(op string, num TypePageNum, err error) error {
+	return withPageOp(op, fh.store.Name(), num, err)
+}
+
+// Checks whether a page number refers to a data page of the file.
+// The header page is not accessible through the page API.
+func (fh *FileHandler) validPageNum(num TypePageNum) bool {
+	return num > FileHeaderPageNum && num < fh.numPages()
+}
+
+// Writes the in-memory header to the header page and marks it dirty.
+// The caller should hold `hdrLatch` exclusively.
+func (fh *FileHandler) writeHeader() error {
+	page, err := fh.bufPool.getPage(fh.store, FileHeaderPageNum, false)
 	if err != nil {
 		return err
 	}
-	fh.hdrMgr = nil
-	fh.bufPool = nil
-	fh.fi = nil
-	return nil
+	defer fh.bufPool.unpinPage(fh.store, FileHeaderPageNum)
+	err = fh.hdrMgr.writeTo(page.memBuffer)
+	if err != nil {
+		return fh.wrapError("write", FileHeaderPageNum, err)
+	}
+	return fh.bufPool.markDirty(fh.store, FileHeaderPageNum)
+}
+
+// Pins a page and checks whether it is in use.
+// Pages in the free list are unpinned immediately and `nil` is returned for them.
+func (fh *FileHandler) getPageIfInUse(num TypePageNum) (*PageHandle, error) {
+	page, err := fh.bufPool.getPage(fh.store, num, false)
+	if err != nil {
+		return nil, err
+	}
+	next, err := page.nextFree()
+	if err != nil || next != InUsePageNum {
+		fh.bufPool.unpinPage(fh.store, num)
+		return nil, fh.wrapError("get", num, err)
+	}
+	return page, nil
+}
+
+// Pins the page with given page number and returns its handle.
+// If the page number is out of range, error `ErrInvalidPageNum` is returned.
+// If the page is in the free list, error `ErrPageDisposed` is returned.
+func (fh *FileHandler) GetThisPage(num TypePageNum) (*PageHandle, error) {
+	if !fh.validPageNum(num) {
+		return nil, fh.wrapError("get", num, ErrInvalidPageNum)
+	}
+	page, err := fh.getPageIfInUse(num)
+	if err != nil {
+		return nil, fh.wrapError("get", num, err)
+	}
+	if page == nil {
+		return nil, fh.wrapError("get", num, ErrPageDisposed)
+	}
+	return page, nil
+}
+
+// Like `GetThisPage`, but if every frame of the pool is pinned, it waits for a frame to be unpinned
+// instead of returning error `ErrNoAvailablePage`, and likewise for `ErrFrameQuotaExceeded`. It gives up with the error of ctx once ctx is done.
+func (fh *FileHandler) GetThisPageContext(ctx context.Context, num TypePageNum) (*PageHandle, error) {
+	var page *PageHandle
+	err := fh.bufPool.waitForFrame(ctx, func() error {
+		var err error
+		page, err = fh.GetThisPage(num)
+		return err
+	})
+	if err != nil {
+		return nil, fh.wrapError("get", num, err)
+	}
+	return page, nil
+}
+
+// Pins and returns the first page in use of the file.
+// If the file has no page in use, error `ErrEndOfFile` is returned.
+func (fh *FileHandler) GetFirstPage() (*PageHandle, error) {
+	return fh.GetNextPage(FileHeaderPageNum)
+}
+
+// Pins and returns the last page in use of the file.
+// If the file has no page in use, error `ErrEndOfFile` is returned.
+func (fh *FileHandler) GetLastPage() (*PageHandle, error) {
+	return fh.GetPrevPage(fh.numPages())
+}
+
+// Pins and returns the first page in use whose page number is greater than `current`.
+// Disposed pages are skipped. The page `current` itself does not need to be pinned or valid.
+// If there is no such page, error `ErrEndOfFile` is returned.
+func (fh *FileHandler) GetNextPage(current TypePageNum) (*PageHandle, error) {
+	if current < FileHeaderPageNum {
+		current = FileHeaderPageNum
+	}
+	for num := current + 1; num < fh.numPages(); num++ {
+		page, err := fh.getPageIfInUse(num)
+		if err != nil {
+			return nil, fh.wrapError("get next", num, err)
+		}
+		if page != nil {
+			return page, nil
+		}
+	}
+	return nil, fh.wrapError("get next", current, ErrEndOfFile)
+}
+
+// Pins and returns the last page in use whose page number is less than `current`.
+// Disposed pages are skipped. The page `current` itself does not need to be pinned or valid.
+// If there is no such page, error `ErrEndOfFile` is returned.
+func (fh *FileHandler) GetPrevPage(current TypePageNum) (*PageHandle, error) {
+	if numPages := fh.numPages(); current > numPages {
+		current = numPages
+	}
+	for num := current - 1; num > FileHeaderPageNum; num-- {
+		page, err := fh.getPageIfInUse(num)
+		if err != nil {
+			return nil, fh.wrapError("get prev", num, err)
+		}
+		if page != nil {
+			return page, nil
+		}
+	}
+	return nil, fh.wrapError("get prev", current, ErrEndOfFile)
+}
+
+// Allocates a page and returns its pinned handle.
+// Pages in the free list are reused first; otherwise a new page is appended to the end of the file.
+// The page is cleared and marked dirty, so that it reaches disk even if it is never modified.
+func (fh *FileHandler) AllocatePage() (*PageHandle, error) {
+	fh.hdrLatch.Lock()
+	defer fh.hdrLatch.Unlock()
+	// The header page is pinned first, so that running out of frames fails before the header is modified.
+	_, err := fh.bufPool.getPage(fh.store, FileHeaderPageNum, false)
+	if err != nil {
+		return nil, fh.wrapError("allocate", FileHeaderPageNum, err)
+	}
+	defer fh.bufPool.unpinPage(fh.store, FileHeaderPageNum)
+	var page *PageHandle
+	if fh.hdrMgr.hdr.FirstFreePage != NonExistPageNum {
+		num := TypePageNum(fh.hdrMgr.hdr.FirstFreePage)
+		page, err = fh.bufPool.getPage(fh.store, num, true)
+		if err != nil {
+			return nil, fh.wrapError("allocate", num, err)
+		}
+		next, err := page.nextFree()
+		if err != nil {
+			fh.bufPool.unpinPage(fh.store, num)
+			return nil, fh.wrapError("allocate", num, err)
+		}
+		page.memBuffer.Clear()
+		fh.hdrMgr.hdr.FirstFreePage = int32(next)
+	} else {
+		num := TypePageNum(fh.hdrMgr.hdr.NumPages)
+		page, err = fh.bufPool.allocatePage(fh.store, num)
+		if err != nil {
+			return nil, fh.wrapError("allocate", num, err)
+		}
+		fh.hdrMgr.hdr.NumPages += 1
+	}
+	err = page.setNextFree(InUsePageNum)
+	if err == nil {
+		err = fh.bufPool.markDirty(fh.store, page.num)
+	}
+	if err == nil {
+		err = fh.writeHeader()
+	}
+	if err != nil {
+		fh.bufPool.unpinPage(fh.store, page.num)
+		return nil, fh.wrapError("allocate", page.num, err)
+	}
+	return page, nil
+}
+
+// Like `AllocatePage`, but if every frame of the pool is pinned, it waits for a frame to be unpinned
+// instead of returning error `ErrNoAvailablePage`, and likewise for `ErrFrameQuotaExceeded`. It gives up with the error of ctx once ctx is done.
+// The header of the file is not locked while waiting.
+func (fh *FileHandler) AllocatePageContext(ctx context.Context) (*PageHandle, error) {
+	var page *PageHandle
+	err := fh.bufPool.waitForFrame(ctx, func() error {
+		var err error
+		page, err = fh.AllocatePage()
+		return err
+	})
+	if err != nil {
+		return nil, fh.wrapError("allocate", NonExistPageNum, err)
+	}
+	return page, nil
+}
+
+// Disposes a page by putting it at the head of the file's free list.
+// The page must not be pinned, otherwise error `ErrPageBeingUsed` is returned.
+// If the page is already in the free list, error `ErrPageDisposed` is returned.
+func (fh *FileHandler) DisposePage(num TypePageNum) error {
+	if !fh.validPageNum(num) {
+		return fh.wrapError("dispose", num, ErrInvalidPageNum)
+	}
+	fh.hdrLatch.Lock()
+	defer fh.hdrLatch.Unlock()
+	page, err := fh.bufPool.getPage(fh.store, num, true)
+	if err != nil {
+		return fh.wrapError("dispose", num, err)
+	}
+	defer fh.bufPool.unpinPage(fh.store, num)
+	next, err := page.nextFree()
+	if err != nil {
+		return fh.wrapError("dispose", num, err)
+	}
+	if next != InUsePageNum {
+		return fh.wrapError("dispose", num, ErrPageDisposed)
+	}
+	err = page.setNextFree(TypePageNum(fh.hdrMgr.hdr.FirstFreePage))
+	if err != nil {
+		return fh.wrapError("dispose", num, err)
+	}
+	err = fh.bufPool.markDirty(fh.store, num)
+	if err != nil {
+		return fh.wrapError("dispose", num, err)
+	}
+	fh.hdrMgr.hdr.FirstFreePage = int32(num)
+	return fh.wrapError("dispose", FileHeaderPageNum, fh.writeHeader())
+}
+
+// Marks a page as dirty. The page must be pinned.
+func (fh *FileHandler) MarkDirty(num TypePageNum) error {
+	if !fh.validPageNum(num) {
+		return fh.wrapError("mark dirty", num, ErrInvalidPageNum)
+	}
+	return fh.wrapError("mark dirty", num, fh.bufPool.markDirty(fh.store, num))
+}
+
+// Unpins a page previously obtained by `GetThisPage` or `AllocatePage`.
+func (fh *FileHandler) UnpinPage(num TypePageNum) error {
+	if !fh.validPageNum(num) {
+		return fh.wrapError("unpin", num, ErrInvalidPageNum)
+	}
+	return fh.wrapError("unpin", num, fh.bufPool.unpinPage(fh.store, num))
+}
+
+// Writes a single page to disk if it is dirty, and syncs the file as required by its sync mode.
+// The page stays in the buffer pool.
+func (fh *FileHandler) ForcePage(num TypePageNum) error {
+	if !fh.validPageNum(num) {
+		return fh.wrapError("force", num, ErrInvalidPageNum)
+	}
+	return fh.wrapError("force", num, fh.bufPool.ForcePage(fh.store, num))
+}
+
+// Hints whether the file is being scanned. While it is, the pages following requested ones are read ahead,
+// even if the access is not detected as sequential.
+func (fh *FileHandler) SetScanHint(scan bool) {
+	fh.bufPool.setScanHint(fh.store, scan)
+}
+
+// Writes all dirty pages of the file, including the header page, to disk,
+// and syncs the file as required by its sync mode.
+func (fh *FileHandler) ForcePages() error {
+	return fh.wrapError("force", NonExistPageNum, fh.bufPool.ForcePages(fh.store))
+}
+
+func (fh *FileHandler) Close() error {
+	name := fh.store.Name()
+	return withPageOp("close", name, NonExistPageNum, fh.bufPool.CloseFile(fh))
 }
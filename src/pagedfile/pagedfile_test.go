@@ -0,0 +1,628 @@
+package pagedfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"pkg/extio"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func utilsOpenNewFile(t *testing.T, pool *BufferPool) (string, *FileHandler) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	assert.Nil(t, pool.CreateFile(fileName), "create file")
+	fh, err := pool.OpenFile(fileName)
+	assert.Nil(t, err, "open file")
+	return fileName, fh
+}
+
+func TestFileHandlerPageAPI(t *testing.T) {
+	pool := NewBufferPool(4)
+	fileName, fh := utilsOpenNewFile(t, pool)
+
+	for i := 1; i <= 6; i++ {
+		page, err := fh.AllocatePage()
+		assert.Nil(t, err, "allocate page", i)
+		assert.Equal(t, TypePageNum(i), page.num, "allocated page num")
+		page.memBuffer.WriteAt([]byte{byte(i)}, 100)
+		assert.Nil(t, fh.UnpinPage(page.num), "unpin page", i)
+	}
+	assert.Equal(t, int32(7), fh.hdrMgr.hdr.NumPages, "num pages after allocation")
+
+	_, err := fh.GetThisPage(FileHeaderPageNum)
+	assert.ErrorIs(t, err, ErrInvalidPageNum, "header page is not accessible")
+	_, err = fh.GetThisPage(7)
+	assert.ErrorIs(t, err, ErrInvalidPageNum, "page out of range")
+	assert.ErrorIs(t, fh.MarkDirty(6), ErrPageNotInUse, "mark dirty on unpinned page")
+
+	page, err := fh.GetThisPage(2)
+	assert.Nil(t, err, "get page")
+	page.memBuffer.WriteAt([]byte{42}, 100)
+	assert.Nil(t, fh.MarkDirty(2), "mark dirty")
+	assert.Nil(t, fh.ForcePage(2), "force page")
+	assert.Equal(t, false, pool.cache[fh.store][2].dirty, "forced page is clean")
+	assert.ErrorIs(t, fh.Close(), ErrPageBeingUsed, "close with pinned page")
+	assert.Nil(t, fh.UnpinPage(2), "unpin page")
+	assert.Nil(t, fh.Close(), "close file")
+
+	fh, err = pool.OpenFile(fileName)
+	assert.Nil(t, err, "reopen file")
+	assert.Equal(t, int32(7), fh.hdrMgr.hdr.NumPages, "num pages after reopen")
+	for i := 1; i <= 6; i++ {
+		page, err := fh.GetThisPage(TypePageNum(i))
+		assert.Nil(t, err, "get page", i)
+		data := make([]byte, 1)
+		page.memBuffer.ReadAt(data, 100)
+		expected := byte(i)
+		if i == 2 {
+			expected = 42
+		}
+		assert.Equal(t, expected, data[0], "page content after reopen", i)
+		assert.Nil(t, fh.UnpinPage(TypePageNum(i)), "unpin page", i)
+	}
+	assert.Nil(t, fh.Close(), "close file")
+}
+
+func TestFileHandlerDisposePage(t *testing.T) {
+	pool := NewBufferPool(4)
+	fileName, fh := utilsOpenNewFile(t, pool)
+
+	for i := 1; i <= 5; i++ {
+		page, err := fh.AllocatePage()
+		assert.Nil(t, err, "allocate page", i)
+		assert.Nil(t, fh.UnpinPage(page.num), "unpin page", i)
+	}
+
+	page, err := fh.GetThisPage(4)
+	assert.Nil(t, err, "get page")
+	assert.ErrorIs(t, fh.DisposePage(4), ErrPageBeingUsed, "dispose pinned page")
+	page.memBuffer.WriteAt([]byte{42}, 100)
+	assert.Nil(t, fh.MarkDirty(4), "mark dirty")
+	assert.Nil(t, fh.UnpinPage(4), "unpin page")
+
+	assert.Nil(t, fh.DisposePage(2), "dispose page 2")
+	assert.Nil(t, fh.DisposePage(4), "dispose page 4")
+	assert.ErrorIs(t, fh.DisposePage(4), ErrPageDisposed, "dispose page twice")
+	_, err = fh.GetThisPage(2)
+	assert.ErrorIs(t, err, ErrPageDisposed, "get disposed page")
+	assert.Equal(t, int32(4), fh.hdrMgr.hdr.FirstFreePage, "head of free list")
+	assert.Nil(t, fh.Close(), "close file")
+
+	fh, err = pool.OpenFile(fileName)
+	assert.Nil(t, err, "reopen file")
+	assert.Equal(t, int32(4), fh.hdrMgr.hdr.FirstFreePage, "free list survives reopen")
+	for _, expected := range []TypePageNum{4, 2, 6} {
+		page, err := fh.AllocatePage()
+		assert.Nil(t, err, "allocate page")
+		assert.Equal(t, expected, page.num, "free pages are reused before growing")
+		data := make([]byte, 1)
+		page.memBuffer.ReadAt(data, 100)
+		assert.Equal(t, byte(0), data[0], "reused page is cleared")
+		assert.Nil(t, fh.UnpinPage(page.num), "unpin page")
+	}
+	assert.Equal(t, int32(NonExistPageNum), fh.hdrMgr.hdr.FirstFreePage, "free list is empty")
+	assert.Equal(t, int32(7), fh.hdrMgr.hdr.NumPages, "num pages")
+	assert.Nil(t, fh.Close(), "close file")
+}
+
+func TestFileHandlerScan(t *testing.T) {
+	pool := NewBufferPool(4)
+	_, fh := utilsOpenNewFile(t, pool)
+
+	_, err := fh.GetFirstPage()
+	assert.ErrorIs(t, err, ErrEndOfFile, "empty file has no first page")
+	_, err = fh.GetLastPage()
+	assert.ErrorIs(t, err, ErrEndOfFile, "empty file has no last page")
+
+	for i := 1; i <= 6; i++ {
+		page, err := fh.AllocatePage()
+		assert.Nil(t, err, "allocate page", i)
+		assert.Nil(t, fh.UnpinPage(page.num), "unpin page", i)
+	}
+	for _, num := range []TypePageNum{1, 3, 4, 6} {
+		assert.Nil(t, fh.DisposePage(num), "dispose page", num)
+	}
+
+	scanned := make([]TypePageNum, 0)
+	page, err := fh.GetFirstPage()
+	for err == nil {
+		scanned = append(scanned, page.num)
+		assert.Nil(t, fh.UnpinPage(page.num), "unpin page")
+		page, err = fh.GetNextPage(page.num)
+	}
+	assert.ErrorIs(t, err, ErrEndOfFile, "forward scan ends with ErrEndOfFile")
+	assert.Equal(t, []TypePageNum{2, 5}, scanned, "forward scan")
+
+	scanned = make([]TypePageNum, 0)
+	page, err = fh.GetLastPage()
+	for err == nil {
+		scanned = append(scanned, page.num)
+		assert.Nil(t, fh.UnpinPage(page.num), "unpin page")
+		page, err = fh.GetPrevPage(page.num)
+	}
+	assert.ErrorIs(t, err, ErrEndOfFile, "backward scan ends with ErrEndOfFile")
+	assert.Equal(t, []TypePageNum{5, 2}, scanned, "backward scan")
+	assert.Nil(t, fh.Close(), "close file")
+}
+
+func TestPageHandleAccessors(t *testing.T) {
+	pool := NewBufferPool(4)
+	_, fh := utilsOpenNewFile(t, pool)
+
+	page, err := fh.AllocatePage()
+	assert.Nil(t, err, "allocate page")
+	assert.Equal(t, TypePageNum(1), page.PageNum(), "page num")
+	assert.Equal(t, PageSize-pageHeaderSize, len(page.Data()), "data size")
+
+	assert.Nil(t, page.WriteInt32(0, -7), "write int32")
+	assert.Nil(t, page.WriteInt64(4, -1<<40), "write int64")
+	assert.Nil(t, page.WriteFloat32(12, 1.5), "write float32")
+	assert.Nil(t, page.WriteFloat64(16, -2.25), "write float64")
+	assert.Equal(t, ErrInvalidOffset, page.WriteUint64(len(page.Data())-4, 1), "write past the end")
+	_, err = page.ReadUint32(-1)
+	assert.Equal(t, ErrInvalidOffset, err, "read before the start")
+	_, err = page.ReadInt32(math.MaxInt - 1)
+	assert.Equal(t, ErrInvalidOffset, err, "read at an offset overflowing the end")
+	assert.Equal(t, ErrInvalidOffset, page.WriteUint64(math.MaxInt, 1), "write at an offset overflowing the end")
+	assert.Equal(t, []byte{0xff, 0xff, 0xff, 0xf9}, page.Data()[:4], "big endian encoding")
+	assert.Nil(t, fh.MarkDirty(page.PageNum()), "mark dirty")
+	assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page")
+	assert.Nil(t, pool.ReleasePages(fh.store), "drop cached pages")
+
+	page, err = fh.GetThisPage(1)
+	assert.Nil(t, err, "get page")
+	i32, err := page.ReadInt32(0)
+	assert.Nil(t, err, "read int32")
+	assert.Equal(t, int32(-7), i32, "int32")
+	i64, err := page.ReadInt64(4)
+	assert.Nil(t, err, "read int64")
+	assert.Equal(t, int64(-1<<40), i64, "int64")
+	f32, err := page.ReadFloat32(12)
+	assert.Nil(t, err, "read float32")
+	assert.Equal(t, float32(1.5), f32, "float32")
+	f64, err := page.ReadFloat64(16)
+	assert.Nil(t, err, "read float64")
+	assert.Equal(t, -2.25, f64, "float64")
+	assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page")
+	assert.Nil(t, fh.Close(), "close file")
+}
+
+func TestFileChecksum(t *testing.T) {
+	for _, checksum := range []bool{true, false} {
+		pool := NewBufferPool(4)
+		fileName := filepath.Join(t.TempDir(), "test.db")
+		assert.Nil(t, pool.CreateFileWithOptions(fileName, FileOptions{Checksum: checksum}), "create file")
+		fh, err := pool.OpenFile(fileName)
+		assert.Nil(t, err, "open file")
+
+		page, err := fh.AllocatePage()
+		assert.Nil(t, err, "allocate page")
+		if checksum {
+			assert.Equal(t, PageSize-pageHeaderSize-pageTrailerSize, len(page.Data()), "trailer is reserved")
+		} else {
+			assert.Equal(t, PageSize-pageHeaderSize, len(page.Data()), "no trailer")
+		}
+		assert.Nil(t, page.WriteInt32(0, 42), "write page")
+		assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page")
+		assert.Nil(t, fh.Close(), "close file")
+
+		fi, err := os.OpenFile(fileName, os.O_RDWR, 0600)
+		assert.Nil(t, err, "open file for corruption")
+		_, err = fi.WriteAt([]byte{0xff}, PageSize+100)
+		assert.Nil(t, err, "corrupt page")
+		assert.Nil(t, fi.Close(), "close corrupted file")
+
+		fh, err = pool.OpenFile(fileName)
+		assert.Nil(t, err, "reopen file")
+		_, err = fh.GetThisPage(1)
+		if checksum {
+			var corrupted *ErrPageCorrupted
+			assert.True(t, errors.As(err, &corrupted), "corruption is detected")
+			assert.Equal(t, fileName, corrupted.File, "file of corrupted page")
+			assert.Equal(t, TypePageNum(1), corrupted.Page, "corrupted page")
+			_, err = fh.GetThisPage(1)
+			assert.True(t, errors.As(err, &corrupted), "corrupted page is not cached")
+		} else {
+			assert.Nil(t, err, "corruption is not detected without checksum")
+			assert.Nil(t, fh.UnpinPage(1), "unpin page")
+		}
+		assert.Nil(t, fh.Close(), "close file")
+	}
+}
+
+func TestZeroedPage(t *testing.T) {
+	pool := NewBufferPool(4)
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	assert.Nil(t, pool.CreateFileWithOptions(fileName, FileOptions{Checksum: true}), "create file")
+	fh, err := pool.OpenFile(fileName)
+	assert.Nil(t, err, "open file")
+	for i := 1; i <= 3; i++ {
+		page, err := fh.AllocatePage()
+		assert.Nil(t, err, "allocate page", i)
+		assert.Nil(t, page.WriteInt32(0, int32(i)), "write page", i)
+		assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page", i)
+	}
+	assert.Nil(t, fh.Close(), "close file")
+
+	fi, err := os.OpenFile(fileName, os.O_RDWR, 0600)
+	assert.Nil(t, err, "open file for corruption")
+	_, err = fi.WriteAt(make([]byte, PageSize), 2*PageSize)
+	assert.Nil(t, err, "zero page")
+	assert.Nil(t, fi.Close(), "close corrupted file")
+
+	fh, err = pool.OpenFile(fileName)
+	assert.Nil(t, err, "reopen file")
+	var corrupted *ErrPageCorrupted
+	_, err = fh.GetThisPage(2)
+	assert.True(t, errors.As(err, &corrupted), "zeroed page is corrupted")
+	assert.Equal(t, TypePageNum(2), corrupted.Page, "corrupted page")
+
+	page, err := fh.GetFirstPage()
+	assert.Nil(t, err, "get first page")
+	assert.Equal(t, TypePageNum(1), page.PageNum(), "first page")
+	_, err = fh.GetNextPage(1)
+	assert.True(t, errors.As(err, &corrupted), "scan does not skip zeroed page")
+	assert.Nil(t, fh.UnpinPage(1), "unpin page")
+	assert.Nil(t, fh.Close(), "close file")
+
+	store := NewMemPageStore("test")
+	buf := make([]byte, PageSize)
+	assert.Nil(t, store.WritePage(0, buf), "write zeroed page")
+	valid, err := pageChecksumValid(store, 0, buf)
+	assert.Nil(t, err, "check page within store")
+	assert.False(t, valid, "zeroed page within store")
+	valid, err = pageChecksumValid(store, 1, buf)
+	assert.Nil(t, err, "check page beyond end")
+	assert.True(t, valid, "zeroed page beyond end has never been written")
+}
+
+func TestFileHeaderValidation(t *testing.T) {
+	putInt32 := func(page []byte, offset int, v int32) {
+		RWBytesOrder.PutUint32(page[offset:], uint32(v))
+	}
+	validPage := func() []byte {
+		page := make([]byte, PageSize)
+		(&FileHeaderMgr{hdr: NewFileHeader()}).writeTo(extio.NewBasicBytesIO(page))
+		return page
+	}
+	testCases := []struct {
+		content func() []byte
+		err     error
+		desc    string
+	}{
+		{
+			content: func() []byte { return []byte{} },
+			err:     ErrNotPagedFile,
+			desc:    "Empty file",
+		},
+		{
+			content: func() []byte { return []byte("This is not a paged file at all.") },
+			err:     ErrNotPagedFile,
+			desc:    "Foreign file",
+		},
+		{
+			content: func() []byte {
+				page := validPage()
+				putInt32(page, 4, FileFormatVersion+1)
+				return page
+			},
+			err:  ErrUnsupportedVersion,
+			desc: "Newer version",
+		},
+		{
+			content: func() []byte {
+				page := validPage()
+				putInt32(page, 4, LegacyFormatVersion)
+				return page
+			},
+			err:  ErrBadFileHeader,
+			desc: "Legacy version with magic number",
+		},
+		{
+			content: func() []byte {
+				page := make([]byte, PageSize)
+				putInt32(page, 0, NonExistPageNum)
+				putInt32(page, 4, 1)
+				return page
+			},
+			err:  nil,
+			desc: "Legacy header",
+		},
+		{
+			content: func() []byte {
+				page := validPage()
+				putInt32(page, 8, 2*PageSize)
+				return page
+			},
+			err:  ErrPageSizeMismatch,
+			desc: "Different page size",
+		},
+		{
+			content: func() []byte {
+				page := validPage()
+				putInt32(page, 20, 0)
+				return page
+			},
+			err:  ErrBadFileHeader,
+			desc: "No page at all",
+		},
+		{
+			content: validPage,
+			err:     nil,
+			desc:    "Valid header",
+		},
+	}
+
+	for _, tc := range testCases {
+		pool := NewBufferPool(4)
+		fileName := filepath.Join(t.TempDir(), "test.db")
+		assert.Nil(t, os.WriteFile(fileName, tc.content(), 0600), "write file", tc.desc)
+		fh, err := pool.OpenFile(fileName)
+		assert.ErrorIs(t, err, tc.err, "error", tc.desc)
+		if err == nil {
+			assert.Nil(t, fh.Close(), "close file", tc.desc)
+		}
+		assert.Equal(t, 0, len(pool.cache), "no page is left in pool", tc.desc)
+	}
+}
+
+func TestFileHeaderMigration(t *testing.T) {
+	// legacy header: only the first free page and the number of pages, without magic number
+	noFreePage, inUse := int32(NonExistPageNum), int32(InUsePageNum)
+	content := make([]byte, 2*PageSize)
+	RWBytesOrder.PutUint32(content[0:], uint32(noFreePage))
+	RWBytesOrder.PutUint32(content[4:], 2)
+	RWBytesOrder.PutUint32(content[PageSize:], uint32(inUse))
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	assert.Nil(t, os.WriteFile(fileName, content, 0600), "write file")
+
+	// the built-in migration upgrades the file without any registration
+	pool := NewBufferPool(4)
+	fh, err := pool.OpenFile(fileName)
+	assert.Nil(t, err, "open legacy file")
+	assert.Equal(t, FileHeader{
+		Magic:         FileMagic,
+		Version:       FileFormatVersion,
+		PageSize:      PageSize,
+		FirstFreePage: NonExistPageNum,
+		NumPages:      2,
+	}, *fh.hdrMgr.hdr, "header after migration")
+	page, err := fh.GetFirstPage()
+	assert.Nil(t, err, "get page")
+	assert.Equal(t, TypePageNum(1), page.PageNum(), "page of migrated file")
+	assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page")
+	assert.Nil(t, fh.Close(), "close file")
+
+	content, err = os.ReadFile(fileName)
+	assert.Nil(t, err, "read file")
+	assert.Equal(t, uint32(FileFormatVersion), RWBytesOrder.Uint32(content[4:]), "upgraded header is written back")
+}
+
+func TestFilePageSize(t *testing.T) {
+	pool := NewBufferPoolWithOptions(PoolOptions{NumPages: 4, PageSize: 4 * PageSize})
+	dir := t.TempDir()
+	assert.ErrorIs(t, pool.CreateFileWithOptions(filepath.Join(dir, "bad.db"), FileOptions{PageSize: 1000}), ErrInvalidPageSize, "page size not a power of two")
+	assert.ErrorIs(t, pool.CreateFileWithOptions(filepath.Join(dir, "bad.db"), FileOptions{PageSize: 2 * MaxPageSize}), ErrInvalidPageSize, "page size too large")
+
+	testCases := []struct {
+		opts FileOptions
+		desc string
+	}{
+		{opts: FileOptions{PageSize: MinPageSize}, desc: "Small pages"},
+		{opts: FileOptions{PageSize: MinPageSize, Checksum: true}, desc: "Small pages with checksum"},
+		{opts: FileOptions{}, desc: "Default pages"},
+		{opts: FileOptions{PageSize: 4 * PageSize, Checksum: true}, desc: "Pages as large as frames"},
+	}
+	fileNames := make([]string, len(testCases))
+	handlers := make([]*FileHandler, len(testCases))
+	for i, tc := range testCases {
+		fileNames[i] = filepath.Join(dir, fmt.Sprintf("test%d.db", i))
+		assert.Nil(t, pool.CreateFileWithOptions(fileNames[i], tc.opts), "create file", tc.desc)
+		fh, err := pool.OpenFile(fileNames[i])
+		assert.Nil(t, err, "open file", tc.desc)
+		handlers[i] = fh
+	}
+	// pages of different files are interleaved in the pool
+	for num := 1; num <= 3; num++ {
+		for i, fh := range handlers {
+			page, err := fh.AllocatePage()
+			assert.Nil(t, err, "allocate page", testCases[i].desc)
+			assert.Nil(t, page.WriteInt32(len(page.Data())-4, int32(num)), "write end of page", testCases[i].desc)
+			assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page", testCases[i].desc)
+		}
+	}
+	for i, fh := range handlers {
+		assert.Nil(t, fh.Close(), "close file", testCases[i].desc)
+	}
+
+	for i, tc := range testCases {
+		pageSize := tc.opts.PageSize
+		if pageSize == 0 {
+			pageSize = PageSize
+		}
+		info, err := os.Stat(fileNames[i])
+		assert.Nil(t, err, "stat file", tc.desc)
+		assert.Equal(t, int64(4*pageSize), info.Size(), "file size", tc.desc)
+
+		fh, err := pool.OpenFile(fileNames[i])
+		assert.Nil(t, err, "reopen file", tc.desc)
+		assert.Equal(t, pageSize, fh.PageSize(), "page size", tc.desc)
+		for num := 1; num <= 3; num++ {
+			page, err := fh.GetThisPage(TypePageNum(num))
+			assert.Nil(t, err, "get page", tc.desc)
+			assert.Equal(t, pageSize, len(page.memBuffer.Bytes()), "buffered page size", tc.desc)
+			v, err := page.ReadInt32(len(page.Data()) - 4)
+			assert.Nil(t, err, "read end of page", tc.desc)
+			assert.Equal(t, int32(num), v, "content of page", tc.desc)
+			assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page", tc.desc)
+		}
+		assert.Nil(t, fh.Close(), "close file", tc.desc)
+	}
+
+	smallPool := NewBufferPool(4)
+	_, err := smallPool.OpenFile(fileNames[len(fileNames)-1])
+	assert.ErrorIs(t, err, ErrPageSizeMismatch, "pages larger than frames")
+}
+
+func TestOpenFileTwice(t *testing.T) {
+	pool := NewBufferPool(4)
+	fileName, fh := utilsOpenNewFile(t, pool)
+	linkName := filepath.Join(filepath.Dir(fileName), "link.db")
+	assert.Nil(t, os.Link(fileName, linkName), "create hard link")
+
+	testCases := []struct {
+		name string
+		desc string
+	}{
+		{name: fileName, desc: "Same name"},
+		{name: filepath.Join(filepath.Dir(fileName), ".", filepath.Base(fileName)), desc: "Different path"},
+		{name: linkName, desc: "Hard link"},
+	}
+	for _, tc := range testCases {
+		other, err := pool.OpenFile(tc.name)
+		assert.Nil(t, err, "open file again", tc.desc)
+		assert.Equal(t, fh, other, "handle is shared", tc.desc)
+	}
+	assert.Equal(t, 1, len(pool.files), "one file in the pool")
+
+	page, err := fh.AllocatePage()
+	assert.Nil(t, err, "allocate page")
+	assert.Nil(t, page.WriteInt32(0, 42), "write page")
+	assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page")
+	for i := range testCases {
+		assert.Nil(t, fh.Close(), "close shared handle", i)
+		assert.NotNil(t, fh.store, "handle is still open", i)
+	}
+	page, err = fh.GetThisPage(1)
+	assert.Nil(t, err, "get page through last reference")
+	v, err := page.ReadInt32(0)
+	assert.Nil(t, err, "read page")
+	assert.Equal(t, int32(42), v, "content of page")
+	assert.Nil(t, fh.UnpinPage(1), "unpin page")
+	assert.Nil(t, fh.Close(), "close last reference")
+	assert.Nil(t, fh.store, "handle is closed")
+	assert.Equal(t, 0, len(pool.files), "no file in the pool")
+
+	fh, err = pool.OpenFile(linkName)
+	assert.Nil(t, err, "reopen file")
+	page, err = fh.GetThisPage(1)
+	assert.Nil(t, err, "get page after reopen")
+	v, err = page.ReadInt32(0)
+	assert.Nil(t, err, "read page")
+	assert.Equal(t, int32(42), v, "content of page after reopen")
+	assert.Nil(t, fh.UnpinPage(1), "unpin page")
+	assert.Nil(t, fh.Close(), "close file")
+
+	store := NewMemPageStore("test")
+	assert.Nil(t, pool.CreateStore(store, FileOptions{}), "create store")
+	fh, err = pool.OpenStore(store)
+	assert.Nil(t, err, "open store")
+	other, err := pool.OpenStore(store)
+	assert.Nil(t, err, "open store again")
+	assert.Equal(t, fh, other, "handle of store is shared")
+	assert.Nil(t, other.Close(), "close store")
+	assert.Nil(t, fh.Close(), "close store again")
+}
+
+func TestBlockingPageAcquisition(t *testing.T) {
+	pool := NewBufferPool(3)
+	_, fh := utilsOpenNewFile(t, pool)
+	for i := 1; i <= 4; i++ {
+		page, err := fh.AllocatePage()
+		assert.Nil(t, err, "allocate page", i)
+		assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page", i)
+	}
+	for i := 1; i <= 3; i++ {
+		_, err := fh.GetThisPage(TypePageNum(i))
+		assert.Nil(t, err, "get page", i)
+	}
+	_, err := fh.GetThisPage(4)
+	assert.ErrorIs(t, err, ErrNoAvailablePage, "non-blocking get page")
+	_, err = fh.AllocatePage()
+	assert.ErrorIs(t, err, ErrNoAvailablePage, "non-blocking allocation")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = fh.GetThisPageContext(ctx, 4)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "get page times out")
+	_, err = fh.AllocatePageContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "allocation times out")
+	assert.Equal(t, int32(5), fh.hdrMgr.hdr.NumPages, "timed out allocation leaves header intact")
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = fh.GetThisPageContext(ctx, 1)
+	assert.Nil(t, err, "cached page does not need a frame")
+	assert.Nil(t, fh.UnpinPage(1), "unpin page")
+	_, err = fh.GetThisPageContext(ctx, 4)
+	assert.ErrorIs(t, err, context.Canceled, "get page is cancelled")
+
+	done := make(chan error)
+	go func() {
+		page, err := fh.GetThisPageContext(context.Background(), 4)
+		if err == nil {
+			err = fh.UnpinPage(page.PageNum())
+		}
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(t, fh.UnpinPage(1), "unpin page")
+	assert.Nil(t, <-done, "get page succeeds once a frame is unpinned")
+
+	go func() {
+		page, err := fh.AllocatePageContext(context.Background())
+		if err == nil {
+			err = fh.UnpinPage(page.PageNum())
+		}
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(t, fh.UnpinPage(2), "unpin page")
+	assert.Nil(t, <-done, "allocation succeeds once a frame is unpinned")
+	assert.Equal(t, int32(6), fh.hdrMgr.hdr.NumPages, "num pages after blocking allocation")
+
+	assert.Nil(t, fh.UnpinPage(3), "unpin page")
+	assert.Nil(t, fh.Close(), "close file")
+}
+
+func TestPageError(t *testing.T) {
+	pool := NewBufferPool(4)
+	fileName, fh := utilsOpenNewFile(t, pool)
+	page, err := fh.AllocatePage()
+	assert.Nil(t, err, "allocate page")
+
+	testCases := []struct {
+		err      error
+		op       string
+		page     TypePageNum
+		sentinel error
+		desc     string
+	}{
+		{err: fh.DisposePage(page.PageNum()), op: "dispose", page: 1, sentinel: ErrPageBeingUsed, desc: "Dispose pinned page"},
+		{err: fh.UnpinPage(2), op: "unpin", page: 2, sentinel: ErrInvalidPageNum, desc: "Unpin invalid page"},
+		{err: fh.Close(), op: "close", page: 1, sentinel: ErrPageBeingUsed, desc: "Close with pinned page"},
+	}
+	for _, tc := range testCases {
+		var pageErr *PageError
+		assert.True(t, errors.As(tc.err, &pageErr), "error is a PageError", tc.desc)
+		assert.Equal(t, tc.op, pageErr.Op, "operation", tc.desc)
+		assert.Equal(t, fileName, pageErr.File, "file", tc.desc)
+		assert.Equal(t, tc.page, pageErr.Page, "page", tc.desc)
+		assert.ErrorIs(t, tc.err, tc.sentinel, "underlying error", tc.desc)
+	}
+	assert.Equal(t, fmt.Sprintf("unpin page 2 of file %s: %v", fileName, ErrInvalidPageNum), testCases[1].err.Error(), "message")
+
+	assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page")
+	assert.Nil(t, fh.Close(), "close file")
+	_, err = pool.OpenFile(fileName + ".missing")
+	var pageErr *PageError
+	assert.True(t, errors.As(err, &pageErr), "error of opening is a PageError")
+	assert.Equal(t, NonExistPageNum, int(pageErr.Page), "error of opening concerns no page")
+	assert.ErrorIs(t, err, os.ErrNotExist, "underlying error of opening")
+}
@@ -0,0 +1,296 @@
+package pagedfile
+
+// ReplacementPolicy decides which frame of a BufferPool is evicted when there is no free frame.
+// Frames are identified by their index in the pool.
+// The pool always calls a policy with its latch held, so implementations need no synchronization of their own.
+type ReplacementPolicy interface {
+	// Init prepares the policy for a pool of `numFrames` frames, none of which holds a page.
+	Init(numFrames int)
+	// Loaded is called when a frame starts holding a page.
+	Loaded(idx TypePoolIdx)
+	// Accessed is called when the page held by a frame is requested again.
+	Accessed(idx TypePoolIdx)
+	// Evicted is called when a frame stops holding a page.
+	Evicted(idx TypePoolIdx)
+	// Victim chooses a frame to evict among the frames holding a page that are accepted by `evictable`.
+	// The second return value is false if there is no such frame.
+	Victim(evictable func(TypePoolIdx) bool) (TypePoolIdx, bool)
+}
+
+// RecencyPolicy is a replacement policy which orders frames by their last use only, like LRU.
+// Besides requests, it is told about other uses of pages, such as marking them dirty,
+// which policies counting references must not see as new references.
+type RecencyPolicy interface {
+	ReplacementPolicy
+	// Touched is called when the page held by a frame is used without being requested again.
+	Touched(idx TypePoolIdx)
+}
+
+const noFrame TypePoolIdx = -1
+
+// frameList is a doubly linked list of frame indices backed by arrays.
+type frameList struct {
+	prev []TypePoolIdx
+	next []TypePoolIdx
+	in   []bool
+	head TypePoolIdx // most recently pushed frame
+	tail TypePoolIdx // least recently pushed frame
+	size int
+}
+
+func newFrameList(numFrames int) *frameList {
+	return &frameList{
+		prev: make([]TypePoolIdx, numFrames),
+		next: make([]TypePoolIdx, numFrames),
+		in:   make([]bool, numFrames),
+		head: noFrame,
+		tail: noFrame,
+	}
+}
+
+func (l *frameList) contains(idx TypePoolIdx) bool {
+	return l.in[idx]
+}
+
+// Inserts a frame at the head of the list. The frame should not be already in the list.
+func (l *frameList) pushHead(idx TypePoolIdx) {
+	l.prev[idx] = noFrame
+	l.next[idx] = l.head
+	if l.head != noFrame {
+		l.prev[l.head] = idx
+	}
+	l.head = idx
+	if l.tail == noFrame {
+		l.tail = idx
+	}
+	l.in[idx] = true
+	l.size += 1
+}
+
+// Removes a frame from the list. It is a no-op if the frame is not in the list.
+func (l *frameList) remove(idx TypePoolIdx) {
+	if !l.in[idx] {
+		return
+	}
+	prev, next := l.prev[idx], l.next[idx]
+	if prev != noFrame {
+		l.next[prev] = next
+	} else {
+		l.head = next
+	}
+	if next != noFrame {
+		l.prev[next] = prev
+	} else {
+		l.tail = prev
+	}
+	l.in[idx] = false
+	l.size -= 1
+}
+
+// Returns the frame nearest to the tail that is accepted by `evictable`.
+func (l *frameList) lastMatching(evictable func(TypePoolIdx) bool) (TypePoolIdx, bool) {
+	for pos := l.tail; pos != noFrame; pos = l.prev[pos] {
+		if evictable(pos) {
+			return pos, true
+		}
+	}
+	return noFrame, false
+}
+
+// LRUPolicy evicts the least recently used frame.
+type LRUPolicy struct {
+	list *frameList
+}
+
+func NewLRUPolicy() *LRUPolicy {
+	return &LRUPolicy{}
+}
+
+func (p *LRUPolicy) Init(numFrames int) {
+	p.list = newFrameList(numFrames)
+}
+
+func (p *LRUPolicy) Loaded(idx TypePoolIdx) {
+	p.list.pushHead(idx)
+}
+
+func (p *LRUPolicy) Accessed(idx TypePoolIdx) {
+	p.list.remove(idx)
+	p.list.pushHead(idx)
+}
+
+func (p *LRUPolicy) Touched(idx TypePoolIdx) {
+	p.Accessed(idx)
+}
+
+func (p *LRUPolicy) Evicted(idx TypePoolIdx) {
+	p.list.remove(idx)
+}
+
+func (p *LRUPolicy) Victim(evictable func(TypePoolIdx) bool) (TypePoolIdx, bool) {
+	return p.list.lastMatching(evictable)
+}
+
+// ClockPolicy approximates LRU with a reference bit per frame and a clock hand sweeping over the frames.
+type ClockPolicy struct {
+	present []bool // whether the frame holds a page
+	ref     []bool // reference bit, cleared when the hand passes by
+	hand    int
+}
+
+func NewClockPolicy() *ClockPolicy {
+	return &ClockPolicy{}
+}
+
+func (p *ClockPolicy) Init(numFrames int) {
+	p.present = make([]bool, numFrames)
+	p.ref = make([]bool, numFrames)
+	p.hand = 0
+}
+
+func (p *ClockPolicy) Loaded(idx TypePoolIdx) {
+	p.present[idx] = true
+	p.ref[idx] = true
+}
+
+func (p *ClockPolicy) Accessed(idx TypePoolIdx) {
+	p.ref[idx] = true
+}
+
+func (p *ClockPolicy) Evicted(idx TypePoolIdx) {
+	p.present[idx] = false
+	p.ref[idx] = false
+}
+
+// Sweeps at most two rounds: the first round may only clear reference bits.
+func (p *ClockPolicy) Victim(evictable func(TypePoolIdx) bool) (TypePoolIdx, bool) {
+	n := len(p.present)
+	for i := 0; i < 2*n; i++ {
+		idx := TypePoolIdx(p.hand)
+		p.hand = (p.hand + 1) % n
+		if !p.present[idx] || !evictable(idx) {
+			continue
+		}
+		if p.ref[idx] {
+			p.ref[idx] = false
+			continue
+		}
+		return idx, true
+	}
+	return noFrame, false
+}
+
+// LRUKPolicy evicts the frame whose K-th most recent access is the oldest.
+// Frames accessed fewer than K times are evicted first, in LRU order,
+// so that pages touched once by a scan do not push out pages that are used repeatedly.
+type LRUKPolicy struct {
+	k       int
+	clock   uint64
+	history [][]uint64 // access times of each frame, most recent first, at most k entries
+}
+
+func NewLRUKPolicy(k int) *LRUKPolicy {
+	if k < 1 {
+		k = 1
+	}
+	return &LRUKPolicy{k: k}
+}
+
+func (p *LRUKPolicy) Init(numFrames int) {
+	p.clock = 0
+	p.history = make([][]uint64, numFrames)
+}
+
+func (p *LRUKPolicy) Loaded(idx TypePoolIdx) {
+	p.history[idx] = make([]uint64, 0, p.k)
+	p.Accessed(idx)
+}
+
+func (p *LRUKPolicy) Accessed(idx TypePoolIdx) {
+	p.clock += 1
+	h := p.history[idx]
+	if len(h) < p.k {
+		h = append(h, 0)
+	}
+	copy(h[1:], h)
+	h[0] = p.clock
+	p.history[idx] = h
+}
+
+func (p *LRUKPolicy) Evicted(idx TypePoolIdx) {
+	p.history[idx] = nil
+}
+
+func (p *LRUKPolicy) Victim(evictable func(TypePoolIdx) bool) (TypePoolIdx, bool) {
+	victim := noFrame
+	victimInf := false
+	var victimTime uint64
+	for i, h := range p.history {
+		idx := TypePoolIdx(i)
+		if h == nil || !evictable(idx) {
+			continue
+		}
+		inf := len(h) < p.k
+		var t uint64
+		if inf {
+			t = h[0] // most recent access, to break ties in LRU order
+		} else {
+			t = h[p.k-1]
+		}
+		if victim == noFrame || (inf && !victimInf) || (inf == victimInf && t < victimTime) {
+			victim, victimInf, victimTime = idx, inf, t
+		}
+	}
+	return victim, victim != noFrame
+}
+
+const twoQueueA1Ratio = 0.25 // share of frames the A1 queue may hold before it is preferred for eviction
+
+// TwoQueuePolicy is a simplified 2Q policy.
+// Newly loaded frames enter the FIFO queue A1, and move to the LRU queue Am when accessed again.
+// Victims are taken from A1 while it holds more than a quarter of the frames, and from Am otherwise,
+// so that a sequential scan only cycles through A1.
+type TwoQueuePolicy struct {
+	a1  *frameList
+	am  *frameList
+	kin int
+}
+
+func NewTwoQueuePolicy() *TwoQueuePolicy {
+	return &TwoQueuePolicy{}
+}
+
+func (p *TwoQueuePolicy) Init(numFrames int) {
+	p.a1 = newFrameList(numFrames)
+	p.am = newFrameList(numFrames)
+	p.kin = int(float64(numFrames) * twoQueueA1Ratio)
+	if p.kin < 1 {
+		p.kin = 1
+	}
+}
+
+func (p *TwoQueuePolicy) Loaded(idx TypePoolIdx) {
+	p.a1.pushHead(idx)
+}
+
+func (p *TwoQueuePolicy) Accessed(idx TypePoolIdx) {
+	p.a1.remove(idx)
+	p.am.remove(idx)
+	p.am.pushHead(idx)
+}
+
+func (p *TwoQueuePolicy) Evicted(idx TypePoolIdx) {
+	p.a1.remove(idx)
+	p.am.remove(idx)
+}
+
+func (p *TwoQueuePolicy) Victim(evictable func(TypePoolIdx) bool) (TypePoolIdx, bool) {
+	first, second := p.am, p.a1
+	if p.a1.size > p.kin {
+		first, second = p.a1, p.am
+	}
+	if idx, ok := first.lastMatching(evictable); ok {
+		return idx, true
+	}
+	return second.lastMatching(evictable)
+}
@@ -0,0 +1,337 @@
+package pagedfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type policyOpType int
+
+const (
+	opLoaded policyOpType = iota
+	opAccessed
+	opEvicted
+)
+
+type policyOp struct {
+	typ policyOpType
+	idx TypePoolIdx
+}
+
+func utilsLoaded(idxs ...TypePoolIdx) []policyOp {
+	ops := make([]policyOp, 0, len(idxs))
+	for _, idx := range idxs {
+		ops = append(ops, policyOp{opLoaded, idx})
+	}
+	return ops
+}
+
+type policyTestCase struct {
+	ops         []policyOp
+	pinned      []TypePoolIdx
+	ok          bool
+	expectedIdx TypePoolIdx
+	desc        string
+}
+
+func utilsTestPolicy(t *testing.T, newPolicy func() ReplacementPolicy, numFrames int, testCases []policyTestCase) {
+	for _, tc := range testCases {
+		// prepare
+		policy := newPolicy()
+		policy.Init(numFrames)
+		for _, op := range tc.ops {
+			switch op.typ {
+			case opLoaded:
+				policy.Loaded(op.idx)
+			case opAccessed:
+				policy.Accessed(op.idx)
+			case opEvicted:
+				policy.Evicted(op.idx)
+			}
+		}
+		pinned := make(map[TypePoolIdx]bool)
+		for _, idx := range tc.pinned {
+			pinned[idx] = true
+		}
+
+		// test
+		idx, ok := policy.Victim(func(idx TypePoolIdx) bool {
+			return !pinned[idx]
+		})
+		assert.Equal(t, tc.ok, ok, "victim found", tc.desc)
+		if tc.ok {
+			assert.Equal(t, tc.expectedIdx, idx, "victim", tc.desc)
+		}
+	}
+}
+
+func TestLRUPolicy(t *testing.T) {
+	utilsTestPolicy(t, func() ReplacementPolicy { return NewLRUPolicy() }, 4, []policyTestCase{
+		{
+			ops:         utilsLoaded(0, 1, 2, 3),
+			ok:          true,
+			expectedIdx: 0,
+			desc:        "Least recently loaded",
+		},
+		{
+			ops:         append(utilsLoaded(0, 1, 2, 3), policyOp{opAccessed, 0}),
+			ok:          true,
+			expectedIdx: 1,
+			desc:        "Access moves page to the front",
+		},
+		{
+			ops:         append(utilsLoaded(0, 1, 2, 3), policyOp{opEvicted, 0}, policyOp{opEvicted, 1}),
+			ok:          true,
+			expectedIdx: 2,
+			desc:        "Evicted pages are forgotten",
+		},
+		{
+			ops:         utilsLoaded(0, 1, 2, 3),
+			pinned:      []TypePoolIdx{0, 1},
+			ok:          true,
+			expectedIdx: 2,
+			desc:        "Pinned pages are skipped",
+		},
+		{
+			ops:    utilsLoaded(0, 1, 2, 3),
+			pinned: []TypePoolIdx{0, 1, 2, 3},
+			ok:     false,
+			desc:   "No available page",
+		},
+	})
+}
+
+func TestClockPolicy(t *testing.T) {
+	utilsTestPolicy(t, func() ReplacementPolicy { return NewClockPolicy() }, 4, []policyTestCase{
+		{
+			ops:         utilsLoaded(0, 1, 2, 3),
+			ok:          true,
+			expectedIdx: 0,
+			desc:        "All referenced, hand sweeps a full round",
+		},
+		{
+			ops:         utilsLoaded(0, 1, 2, 3),
+			pinned:      []TypePoolIdx{0},
+			ok:          true,
+			expectedIdx: 1,
+			desc:        "Pinned pages are skipped",
+		},
+		{
+			ops:    utilsLoaded(0, 1, 2, 3),
+			pinned: []TypePoolIdx{0, 1, 2, 3},
+			ok:     false,
+			desc:   "No available page",
+		},
+		{
+			ops:         append(utilsLoaded(0, 1, 2, 3), policyOp{opEvicted, 0}, policyOp{opEvicted, 1}),
+			ok:          true,
+			expectedIdx: 2,
+			desc:        "Evicted pages are not chosen",
+		},
+	})
+
+	// The hand keeps its position between calls.
+	policy := NewClockPolicy()
+	policy.Init(4)
+	for _, op := range utilsLoaded(0, 1, 2, 3) {
+		policy.Loaded(op.idx)
+	}
+	all := func(TypePoolIdx) bool { return true }
+	idx, _ := policy.Victim(all)
+	assert.Equal(t, TypePoolIdx(0), idx, "first victim")
+	policy.Evicted(0)
+	policy.Loaded(0)
+	policy.Accessed(1)
+	idx, _ = policy.Victim(all)
+	assert.Equal(t, TypePoolIdx(2), idx, "referenced page after the hand gets a second chance")
+}
+
+func TestLRUKPolicy(t *testing.T) {
+	utilsTestPolicy(t, func() ReplacementPolicy { return NewLRUKPolicy(2) }, 4, []policyTestCase{
+		{
+			ops:         append(utilsLoaded(0, 1, 2, 3), policyOp{opAccessed, 0}, policyOp{opAccessed, 1}),
+			ok:          true,
+			expectedIdx: 2,
+			desc:        "Pages accessed less than K times go first, in LRU order",
+		},
+		{
+			ops: append(utilsLoaded(0, 1, 2, 3),
+				policyOp{opAccessed, 0}, policyOp{opAccessed, 1}, policyOp{opAccessed, 2}, policyOp{opAccessed, 3},
+				policyOp{opAccessed, 0}),
+			ok:          true,
+			expectedIdx: 1,
+			desc:        "Oldest K-th most recent access",
+		},
+		{
+			ops:         append(utilsLoaded(0, 1, 2, 3), policyOp{opAccessed, 1}, policyOp{opAccessed, 0}),
+			pinned:      []TypePoolIdx{2, 3},
+			ok:          true,
+			expectedIdx: 0,
+			desc:        "Pinned pages are skipped",
+		},
+		{
+			ops:    utilsLoaded(0, 1, 2, 3),
+			pinned: []TypePoolIdx{0, 1, 2, 3},
+			ok:     false,
+			desc:   "No available page",
+		},
+	})
+}
+
+func TestTwoQueuePolicy(t *testing.T) {
+	utilsTestPolicy(t, func() ReplacementPolicy { return NewTwoQueuePolicy() }, 8, []policyTestCase{
+		{
+			ops:         utilsLoaded(0, 1, 2, 3),
+			ok:          true,
+			expectedIdx: 0,
+			desc:        "A1 is over its share, evict from A1 in FIFO order",
+		},
+		{
+			ops:         append(utilsLoaded(0, 1, 2, 3, 4), policyOp{opAccessed, 0}, policyOp{opAccessed, 1}),
+			ok:          true,
+			expectedIdx: 2,
+			desc:        "Pages accessed again are protected in Am",
+		},
+		{
+			ops:         append(utilsLoaded(0, 1, 2, 3), policyOp{opAccessed, 1}, policyOp{opAccessed, 0}),
+			ok:          true,
+			expectedIdx: 1,
+			desc:        "A1 is within its share, evict from Am in LRU order",
+		},
+		{
+			ops:         append(utilsLoaded(0, 1, 2, 3), policyOp{opAccessed, 0}),
+			pinned:      []TypePoolIdx{1, 2, 3},
+			ok:          true,
+			expectedIdx: 0,
+			desc:        "Fall back to Am when every page of A1 is pinned",
+		},
+		{
+			ops:    append(utilsLoaded(0, 1, 2, 3), policyOp{opAccessed, 0}),
+			pinned: []TypePoolIdx{0, 1, 2, 3},
+			ok:     false,
+			desc:   "No available page",
+		},
+	})
+}
+
+func TestBufferPoolWithPolicy(t *testing.T) {
+	testCases := []struct {
+		policy      ReplacementPolicy
+		expectedIdx TypePoolIdx
+		desc        string
+	}{
+		{policy: NewLRUPolicy(), expectedIdx: 0, desc: "LRU"},
+		{policy: NewClockPolicy(), expectedIdx: 0, desc: "Clock"},
+		{policy: NewLRUKPolicy(2), expectedIdx: 2, desc: "LRU-2"},
+		{policy: NewTwoQueuePolicy(), expectedIdx: 2, desc: "2Q"},
+	}
+
+	for _, tc := range testCases {
+		// prepare: pages 0 and 1 are used twice, then pages 2 and 3 are scanned
+		pool := NewBufferPoolWithOptions(PoolOptions{NumPages: 4, Policy: tc.policy})
+		load := func(idx TypePoolIdx) {
+			page := pool.buffer[idx]
+			page.num = TypePageNum(idx)
+			page.file = &fileState{}
+			pool.load(page)
+		}
+		load(0)
+		load(1)
+		pool.policy.Accessed(0)
+		pool.policy.Accessed(1)
+		load(2)
+		load(3)
+
+		// test
+		page, err := pool.findAvailablePage(nil)
+		assert.Nil(t, err, "error", tc.desc)
+		assert.Equal(t, tc.expectedIdx, page.idx, "evicted page", tc.desc)
+		assert.Equal(t, page, pool.headFree, "evicted page is the head of free list", tc.desc)
+	}
+}
+
+func TestMarkDirtyPolicy(t *testing.T) {
+	store := utilsNewMemFile(t, 4)
+	pool := NewBufferPoolWithOptions(PoolOptions{NumPages: 3, Policy: NewLRUPolicy()})
+	fh, err := pool.OpenStore(store)
+	assert.Nil(t, err, "open store")
+
+	// pages 1 and 2 are requested, then page 1 is marked dirty after page 2 is unpinned
+	for _, num := range []TypePageNum{1, 2} {
+		_, err = fh.GetThisPage(num)
+		assert.Nil(t, err, "get page", num)
+	}
+	assert.Nil(t, fh.UnpinPage(2), "unpin page 2")
+	assert.Nil(t, fh.MarkDirty(1), "mark dirty")
+	assert.Nil(t, fh.UnpinPage(1), "unpin page 1")
+
+	// the header page is evicted, then page 2, which was used less recently than page 1
+	for _, num := range []TypePageNum{3, 4} {
+		_, err = fh.GetThisPage(num)
+		assert.Nil(t, err, "get page", num)
+		assert.Nil(t, fh.UnpinPage(num), "unpin page", num)
+	}
+	_, ok := pool.cache[store][1]
+	assert.True(t, ok, "page marked dirty is kept")
+	_, ok = pool.cache[store][2]
+	assert.False(t, ok, "least recently used page is evicted")
+	assert.Equal(t, int64(0), pool.Stats().DirtyWriteBacks, "no write-back")
+	assert.Nil(t, fh.Close(), "close store")
+
+	// marking a page dirty is not a second reference for policies counting references
+	testCases := []struct {
+		newPolicy func() ReplacementPolicy
+		desc      string
+	}{
+		{newPolicy: func() ReplacementPolicy { return NewTwoQueuePolicy() }, desc: "2Q"},
+		{newPolicy: func() ReplacementPolicy { return NewLRUKPolicy(2) }, desc: "LRU-2"},
+	}
+	for _, tc := range testCases {
+		assert.True(t, utilsScanKeepsHotPages(t, tc.newPolicy(), 0, true, tc.desc), "hot pages survive an updating scan", tc.desc)
+	}
+}
+
+// Requests the hot pages 1 and 2 twice, then scans the following pages of a file once, marking them dirty
+// if `update` is set. It returns whether the hot pages are still cached after the scan.
+func utilsScanKeepsHotPages(t *testing.T, policy ReplacementPolicy, readAhead int, update bool, desc string) bool {
+	store := utilsNewMemFile(t, 40)
+	pool := NewBufferPoolWithOptions(PoolOptions{NumPages: 16, Policy: policy, ReadAhead: readAhead})
+	fh, err := pool.OpenStore(store)
+	assert.Nil(t, err, "open store", desc)
+	nums := []TypePageNum{1, 2, 1, 2}
+	for i := 3; i <= 40; i++ {
+		nums = append(nums, TypePageNum(i))
+	}
+	for i, num := range nums {
+		_, err := fh.GetThisPage(num)
+		assert.Nil(t, err, "get page", num, desc)
+		if update && i >= 4 {
+			assert.Nil(t, fh.MarkDirty(num), "mark dirty", num, desc)
+		}
+		assert.Nil(t, fh.UnpinPage(num), "unpin page", num, desc)
+		pool.prefetches.Wait()
+	}
+	if readAhead > 0 {
+		assert.True(t, pool.Stats().PrefetchHits > 0, "pages are read ahead", desc)
+	}
+	_, hot1 := pool.cache[store][1]
+	_, hot2 := pool.cache[store][2]
+	assert.Nil(t, fh.Close(), "close store", desc)
+	return hot1 && hot2
+}
+
+func TestReadAheadScanResistance(t *testing.T) {
+	testCases := []struct {
+		newPolicy func() ReplacementPolicy
+		desc      string
+	}{
+		{newPolicy: func() ReplacementPolicy { return NewTwoQueuePolicy() }, desc: "2Q"},
+		{newPolicy: func() ReplacementPolicy { return NewLRUKPolicy(2) }, desc: "LRU-2"},
+	}
+	for _, tc := range testCases {
+		assert.True(t, utilsScanKeepsHotPages(t, tc.newPolicy(), 0, false, tc.desc), "hot pages survive a scan", tc.desc)
+		assert.True(t, utilsScanKeepsHotPages(t, tc.newPolicy(), 4, false, tc.desc),
+			"hot pages survive a scan read ahead", tc.desc)
+	}
+}
@@ -0,0 +1,103 @@
+package pagedfile
+
+import "errors"
+
+// Sets the frame quota of the file: `minFrames` frames are reserved for its pages, and it holds at most `maxFrames`
+// frames, 0 meaning no reservation and no cap respectively. The quota lasts until the file is closed by everyone.
+//
+// Frames reserved for a file and not holding its pages yet are left free for it, and its pages are not evicted
+// for other files as long as it holds no more than `minFrames` frames. Once the file holds `maxFrames` frames,
+// its pages evict each other; if they are all pinned, requesting another page fails with `ErrFrameQuotaExceeded`.
+// A file holding more frames than its cap, for instance after the cap is lowered, gives one back per new page.
+// Shrinking the pool with `Resize` ignores reservations.
+// If a bound is negative, or the reservation is larger than the cap, error `ErrInvalidQuota` is returned.
+func (fh *FileHandler) SetFrameQuota(minFrames int, maxFrames int) error {
+	if minFrames < 0 || maxFrames < 0 || (maxFrames > 0 && minFrames > maxFrames) {
+		return fh.wrapError("set quota", NonExistPageNum, ErrInvalidQuota)
+	}
+	fh.bufPool.latch.Lock()
+	defer fh.bufPool.latch.Unlock()
+	state := fh.bufPool.fileStateOf(fh.store)
+	state.minFrames = minFrames
+	state.maxFrames = maxFrames
+	return nil
+}
+
+// Returns whether a file holds as many frames as its cap allows. A nil file has no cap.
+func (bp *BufferPool) atFrameCap(file *fileState) bool {
+	return file != nil && file.maxFrames > 0 && file.frames >= file.maxFrames
+}
+
+// Returns whether the frame of a page may be taken for a page of given file, or for a scratch block if file is nil:
+// either the page belongs to the same file, or its file keeps more frames than reserved once the page is evicted.
+func (bp *BufferPool) mayTakeFrame(file *fileState, page *BufferedPage) bool {
+	return page.file == file || page.file.minFrames == 0 || page.file.frames > page.file.minFrames
+}
+
+// Returns the number of frames reserved for files other than given one and not holding their pages yet.
+func (bp *BufferPool) reservedFrames(file *fileState) int {
+	reserved := 0
+	for _, state := range bp.files {
+		if state != file && state.frames < state.minFrames {
+			reserved += state.minFrames - state.frames
+		}
+	}
+	return reserved
+}
+
+// Returns the first free frame if it may be used by given file, or nil.
+// A file below its reservation may use any free frame; other files may only use free frames not reserved for others.
+func (bp *BufferPool) freeFrameFor(file *fileState) *BufferedPage {
+	if bp.headFree == nil {
+		return nil
+	}
+	if file != nil && file.frames < file.minFrames {
+		return bp.headFree
+	}
+	reserved := bp.reservedFrames(file)
+	if reserved == 0 {
+		return bp.headFree
+	}
+	numFree := 0
+	for pos := bp.headFree; pos != nil && numFree <= reserved; pos = pos.next {
+		numFree += 1
+	}
+	if numFree > reserved {
+		return bp.headFree
+	}
+	return nil
+}
+
+// Evicts an unpinned page of a file which reached its cap, so that its frame can hold another page of the file.
+// If all pages of the file are pinned, error `ErrFrameQuotaExceeded` is returned.
+func (bp *BufferPool) evictOverCap(file *fileState) (*BufferedPage, error) {
+	page, err := bp.evictVictim(func(page *BufferedPage) bool {
+		return page.file == file
+	})
+	if errors.Is(err, ErrNoAvailablePage) {
+		bp.stats.QuotaDenials += 1
+		file.stats.QuotaDenials += 1
+		return nil, ErrFrameQuotaExceeded
+	}
+	if err != nil {
+		return nil, err
+	}
+	bp.stats.QuotaEvictions += 1
+	file.stats.QuotaEvictions += 1
+	return page, nil
+}
+
+// Counts a request which found no frame, if a frame would have been available without the reservations of other files.
+func (bp *BufferPool) countReserveDenial(file *fileState) {
+	available := bp.headFree != nil
+	for pos := bp.headUsed; pos != nil && !available; pos = pos.next {
+		available = pos.pinned == 0 && !pos.loading
+	}
+	if !available {
+		return
+	}
+	bp.stats.ReserveDenials += 1
+	if file != nil {
+		file.stats.ReserveDenials += 1
+	}
+}
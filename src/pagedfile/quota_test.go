@@ -0,0 +1,89 @@
+package pagedfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrameQuotas(t *testing.T) {
+	pool := NewBufferPool(6)
+	catalogStore := utilsNewMemFile(t, 4)
+	scanStore := utilsNewMemFile(t, 8)
+	otherStore := utilsNewMemFile(t, 8)
+	catalog, err := pool.OpenStore(catalogStore)
+	assert.Nil(t, err, "open catalog")
+	scan, err := pool.OpenStore(scanStore)
+	assert.Nil(t, err, "open scan")
+
+	testCases := []struct {
+		minFrames int
+		maxFrames int
+		desc      string
+	}{
+		{minFrames: -1, maxFrames: 0, desc: "negative reservation"},
+		{minFrames: 0, maxFrames: -1, desc: "negative cap"},
+		{minFrames: 3, maxFrames: 2, desc: "reservation larger than cap"},
+	}
+	for _, tc := range testCases {
+		assert.ErrorIs(t, scan.SetFrameQuota(tc.minFrames, tc.maxFrames), ErrInvalidQuota, tc.desc)
+	}
+	assert.Nil(t, catalog.SetFrameQuota(2, 0), "reserve frames for catalog")
+	assert.Nil(t, scan.SetFrameQuota(0, 3), "cap frames of scan")
+
+	// the scan cycles through its own frames
+	for i := 1; i <= 8; i++ {
+		utilsReadPage(t, scan, TypePageNum(i), "scan")
+	}
+	stats := pool.Stats()
+	assert.Equal(t, 3, pool.files[scanStore].frames, "frames of scan")
+	assert.Equal(t, 1, pool.files[catalogStore].frames, "frames of catalog holding its header")
+	assert.Equal(t, 2, stats.FreeFrames, "frames left free")
+	assert.Equal(t, int64(6), pool.files[scanStore].stats.QuotaEvictions, "evictions of scan at its cap")
+	assert.Equal(t, int64(6), stats.QuotaEvictions, "evictions at caps")
+	assert.Equal(t, int64(0), pool.files[catalogStore].stats.Evictions, "catalog is untouched")
+
+	for i := 6; i <= 8; i++ {
+		_, err = scan.GetThisPage(TypePageNum(i))
+		assert.Nil(t, err, "pin page of scan", i)
+	}
+	_, err = scan.GetThisPage(1)
+	assert.ErrorIs(t, err, ErrFrameQuotaExceeded, "scan has every frame of its cap pinned")
+	assert.Equal(t, int64(1), pool.Stats().QuotaDenials, "denial at cap")
+	for i := 6; i <= 8; i++ {
+		assert.Nil(t, scan.UnpinPage(TypePageNum(i)), "unpin page of scan", i)
+	}
+
+	// a file without quota takes every frame but those reserved for the catalog
+	other, err := pool.OpenStore(otherStore)
+	assert.Nil(t, err, "open other")
+	var pinned []TypePageNum
+	for i := 1; i <= 8; i++ {
+		_, err = other.GetThisPage(TypePageNum(i))
+		if err != nil {
+			assert.ErrorIs(t, err, ErrNoAvailablePage, "remaining frames are reserved", i)
+			break
+		}
+		pinned = append(pinned, TypePageNum(i))
+	}
+	stats = pool.Stats()
+	assert.Equal(t, int64(1), stats.ReserveDenials, "denial because of reservation")
+	assert.Equal(t, 0, pool.files[scanStore].frames, "frames of scan are taken")
+	assert.Equal(t, 2, pool.files[catalogStore].frames+stats.FreeFrames, "reservation of catalog is kept")
+	_, err = pool.AllocateBlock()
+	assert.ErrorIs(t, err, ErrNoAvailablePage, "blocks do not take reserved frames")
+	utilsReadPage(t, catalog, 1, "catalog")
+	assert.Equal(t, 2, pool.files[catalogStore].frames, "catalog gets its reserved frames")
+	utilsCheckPoolLists(t, pool, "frame quotas")
+
+	for _, num := range pinned {
+		assert.Nil(t, other.UnpinPage(num), "unpin page of other", num)
+	}
+	_, err = scan.GetThisPage(1)
+	assert.Nil(t, err, "scan gets a frame once other pages are unpinned")
+	assert.Nil(t, scan.UnpinPage(1), "unpin page of scan")
+	for _, fh := range []*FileHandler{catalog, scan, other} {
+		assert.Nil(t, fh.Close(), "close file")
+	}
+	assert.Equal(t, 0, pool.Stats().UsedFrames, "no frame is used after closing")
+}
@@ -0,0 +1,133 @@
+package pagedfile
+
+import "sync"
+
+// Number of pages read ahead for files with a scan hint, if the pool has no read-ahead configured.
+const DefaultReadAhead = 8
+
+// Number of consecutive requests for the following page, after which access to a file is considered sequential.
+const sequentialThreshold = 2
+
+// Records a request for a page of a file, and starts reading ahead the pages following it
+// if the file is accessed sequentially or has a scan hint, unless the file or the pool is being closed.
+// Pages are read ahead by windows: the next window is read once half of the current one has been requested.
+func (bp *BufferPool) accessed(store PageStore, state *fileState, num TypePageNum) {
+	if num == FileHeaderPageNum {
+		return
+	}
+	if num == state.lastNum+1 {
+		state.seqRun += 1
+	} else {
+		state.seqRun = 0
+		state.prefetchEnd = num + 1
+	}
+	state.lastNum = num
+
+	count := bp.readAhead
+	if state.scanHint {
+		if count == 0 {
+			count = DefaultReadAhead
+		}
+	} else if count == 0 || state.seqRun < sequentialThreshold {
+		return
+	}
+	if state.handler == nil || state.closing || bp.closed || state.prefetchEnd-num > TypePageNum((count+1)/2) {
+		return
+	}
+	from := state.prefetchEnd
+	if from <= num {
+		from = num + 1
+	}
+	to := num + 1 + TypePageNum(count)
+	state.prefetchEnd = to
+	state.prefetches.Add(1)
+	bp.prefetches.Add(1)
+	go bp.prefetch(store, state.handler, &state.prefetches, from, to)
+}
+
+// Reads ahead pages `[from, to)` of a file, stopping at the end of the file, or when no frame is available.
+// It marks the read-ahead done in `running`, the running read-ahead of the file, as well as in the pool.
+func (bp *BufferPool) prefetch(store PageStore, handler *FileHandler, running *sync.WaitGroup, from TypePageNum, to TypePageNum) {
+	defer bp.prefetches.Done()
+	defer running.Done()
+	if numPages := handler.numPages(); to > numPages {
+		to = numPages
+	}
+	for num := from; num < to; num++ {
+		if !bp.prefetchPage(store, num) {
+			return
+		}
+	}
+}
+
+// Reads a page ahead into a free frame, or into the frame of a clean unpinned page, and leaves it unpinned.
+// It returns false if there is no such frame within the frame quotas, if the file is closed, or if the page cannot be read.
+func (bp *BufferPool) prefetchPage(store PageStore, num TypePageNum) bool {
+	bp.latch.Lock()
+	state, ok := bp.files[store]
+	if !ok {
+		bp.latch.Unlock()
+		return false
+	}
+	if _, ok := bp.cache[store][num]; ok {
+		bp.latch.Unlock()
+		return true
+	}
+	if bp.atFrameCap(state) {
+		bp.latch.Unlock()
+		return false
+	}
+	page := bp.freeFrameFor(state)
+	if page == nil {
+		// read-ahead is not worth writing dirty pages back
+		idx, ok := bp.policy.Victim(func(idx TypePoolIdx) bool {
+			pos := bp.buffer[idx]
+			return pos.pinned == 0 && !pos.loading && !pos.dirty && bp.mayTakeFrame(state, pos)
+		})
+		if !ok {
+			bp.latch.Unlock()
+			return false
+		}
+		page = bp.buffer[idx]
+		bp.stats.Evictions += 1
+		page.file.stats.Evictions += 1
+		bp.evict(page)
+	}
+	page.setNewFile(store, state, num)
+	page.loading = true
+	page.prefetched = true
+	bp.stats.DiskReads += 1
+	bp.stats.PrefetchedPages += 1
+	state.stats.DiskReads += 1
+	state.stats.PrefetchedPages += 1
+	page.latch.Lock()
+	bp.load(page)
+	bp.latch.Unlock()
+
+	err := page.readFromDisk()
+	bp.latch.Lock()
+	defer bp.latch.Unlock()
+	page.loading = false
+	bp.notifyAvailable()
+	if err != nil {
+		page.loadErr = err
+		page.prefetched = false
+		delete(bp.cache[store], num)
+		if len(bp.cache[store]) == 0 {
+			delete(bp.cache, store)
+		}
+		// requests which found the page while it was loading return it to the free queue once they unpin it
+		if page.pinned == 0 {
+			bp.unload(page)
+		}
+	}
+	page.latch.Unlock()
+	return err == nil
+}
+
+// Sets the scan hint of a file.
+func (bp *BufferPool) setScanHint(store PageStore, scan bool) {
+	bp.latch.Lock()
+	defer bp.latch.Unlock()
+	bp.fileStateOf(store).scanHint = scan
+}
@@ -0,0 +1,209 @@
+package pagedfile
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Creates an in-memory file with given number of data pages, the first bytes of each holding its page num.
+func utilsNewMemFile(t *testing.T, numPages int) PageStore {
+	store := NewMemPageStore("test")
+	pool := NewBufferPool(4)
+	assert.Nil(t, pool.CreateStore(store, FileOptions{}), "create store")
+	fh, err := pool.OpenStore(store)
+	assert.Nil(t, err, "open store")
+	for i := 1; i <= numPages; i++ {
+		page, err := fh.AllocatePage()
+		assert.Nil(t, err, "allocate page", i)
+		assert.Nil(t, page.WriteInt32(0, int32(i)), "write page", i)
+		assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page", i)
+	}
+	assert.Nil(t, fh.Close(), "close store")
+	return store
+}
+
+// Gets a page, checks its content and unpins it, then waits for the read-ahead it started.
+func utilsReadPage(t *testing.T, fh *FileHandler, num TypePageNum, desc string) {
+	page, err := fh.GetThisPage(num)
+	assert.Nil(t, err, "get page", num, desc)
+	v, err := page.ReadInt32(0)
+	assert.Nil(t, err, "read page", num, desc)
+	assert.Equal(t, int32(num), v, "content of page", num, desc)
+	assert.Nil(t, fh.UnpinPage(num), "unpin page", num, desc)
+	fh.bufPool.prefetches.Wait()
+}
+
+func TestReadAhead(t *testing.T) {
+	testCases := []struct {
+		nums               []TypePageNum
+		expectedMisses     int64
+		expectedPrefetched int64
+		desc               string
+	}{
+		{
+			nums:               []TypePageNum{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20},
+			expectedMisses:     2,
+			expectedPrefetched: 18,
+			desc:               "Sequential scan",
+		},
+		{
+			nums:               []TypePageNum{5, 2, 9, 1, 17, 3},
+			expectedMisses:     6,
+			expectedPrefetched: 0,
+			desc:               "Random access",
+		},
+		{
+			nums:               []TypePageNum{7, 8, 9, 10, 11, 3},
+			expectedMisses:     4,
+			expectedPrefetched: 4,
+			desc:               "Short run, then jump backwards",
+		},
+	}
+
+	numFilePages := 20
+	store := utilsNewMemFile(t, numFilePages)
+	for _, tc := range testCases {
+		pool := NewBufferPoolWithOptions(PoolOptions{NumPages: 16, ReadAhead: 4})
+		fh, err := pool.OpenStore(store)
+		assert.Nil(t, err, "open store", tc.desc)
+		before := pool.Stats()
+		for _, num := range tc.nums {
+			utilsReadPage(t, fh, num, tc.desc)
+		}
+		stats := pool.Stats()
+		assert.Equal(t, tc.expectedMisses, stats.Misses-before.Misses, "misses", tc.desc)
+		assert.Equal(t, tc.expectedPrefetched, stats.PrefetchedPages, "prefetched pages", tc.desc)
+		assert.Equal(t, int64(len(tc.nums))-tc.expectedMisses, stats.PrefetchHits, "prefetch hits", tc.desc)
+		assert.Equal(t, int64(0), stats.PrefetchWasted, "wasted prefetches", tc.desc)
+		assert.Equal(t, stats.PrefetchedPages, stats.Files["test"].PrefetchedPages, "prefetched pages of file", tc.desc)
+		assert.Nil(t, fh.Close(), "close store", tc.desc)
+		assert.Nil(t, pool.Close(), "close pool", tc.desc)
+	}
+}
+
+func TestScanHint(t *testing.T) {
+	store := utilsNewMemFile(t, 20)
+	pool := NewBufferPool(16)
+	fh, err := pool.OpenStore(store)
+	assert.Nil(t, err, "open store")
+
+	utilsReadPage(t, fh, 12, "without hint")
+	assert.Equal(t, int64(0), pool.Stats().PrefetchedPages, "no read-ahead without hint")
+
+	fh.SetScanHint(true)
+	utilsReadPage(t, fh, 1, "with hint")
+	assert.Equal(t, int64(DefaultReadAhead), pool.Stats().PrefetchedPages, "pages read ahead with hint")
+	utilsReadPage(t, fh, 2, "with hint")
+	fh.SetScanHint(false)
+	utilsReadPage(t, fh, 3, "hint removed")
+	utilsReadPage(t, fh, 15, "hint removed")
+
+	stats := pool.Stats()
+	assert.Equal(t, int64(DefaultReadAhead), stats.PrefetchedPages, "no more read-ahead after hint is removed")
+	assert.Equal(t, int64(2), stats.PrefetchHits, "prefetch hits")
+	for _, page := range pool.buffer {
+		assert.Equal(t, 0, page.pinned, "read-ahead leaves pages unpinned", page.idx)
+	}
+	assert.Nil(t, fh.Close(), "close store")
+	assert.Equal(t, int64(DefaultReadAhead-2), pool.Stats().PrefetchWasted, "unused pages are wasted")
+	assert.Nil(t, pool.Close(), "close pool")
+}
+
+func TestConcurrentReadAhead(t *testing.T) {
+	numFilePages := 32
+	store := utilsNewMemFile(t, numFilePages)
+	pool := NewBufferPoolWithOptions(PoolOptions{NumPages: 8, ReadAhead: 4})
+	fh, err := pool.OpenStore(store)
+	assert.Nil(t, err, "open store")
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for num := TypePageNum(1); num <= TypePageNum(numFilePages); num++ {
+				page, err := fh.GetThisPage(num)
+				if errors.Is(err, ErrNoAvailablePage) {
+					continue
+				}
+				if !assert.Nil(t, err, "get page", num) {
+					return
+				}
+				v, err := page.ReadInt32(0)
+				assert.Nil(t, err, "read page", num)
+				assert.Equal(t, int32(num), v, "content of page", num)
+				assert.Nil(t, fh.UnpinPage(num), "unpin page", num)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Nil(t, fh.Close(), "close store")
+	assert.Nil(t, pool.Close(), "close pool")
+	stats := pool.Stats()
+	assert.True(t, stats.PrefetchHits+stats.PrefetchWasted <= stats.PrefetchedPages, "prefetched pages are used at most once")
+}
+
+// blockingReadStore blocks reads of pages after page 1, once enabled, until `release` is closed.
+type blockingReadStore struct {
+	PageStore
+	block       bool
+	reading     chan struct{} // closed when the first blocked read starts
+	readingOnce sync.Once
+	release     chan struct{}
+}
+
+func (s *blockingReadStore) ReadPage(num TypePageNum, buf []byte) error {
+	if s.block && num > 1 {
+		s.readingOnce.Do(func() { close(s.reading) })
+		<-s.release
+	}
+	return s.PageStore.ReadPage(num, buf)
+}
+
+func TestCloseWithReadAheadOfOtherFile(t *testing.T) {
+	pool := NewBufferPool(16)
+	scanStore := &blockingReadStore{
+		PageStore: utilsNewMemFile(t, 20),
+		reading:   make(chan struct{}),
+		release:   make(chan struct{}),
+	}
+	scan, err := pool.OpenStore(scanStore)
+	assert.Nil(t, err, "open scanned store")
+	other, err := pool.OpenStore(utilsNewMemFile(t, 4))
+	assert.Nil(t, err, "open other store")
+
+	scanStore.block = true
+	scan.SetScanHint(true)
+	_, err = scan.GetThisPage(1)
+	assert.Nil(t, err, "get page, starting read-ahead")
+	<-scanStore.reading
+
+	closed := make(chan error)
+	go func() {
+		closed <- other.Close()
+	}()
+	select {
+	case err = <-closed:
+		assert.Nil(t, err, "close other store while the scanned one is read ahead")
+	case <-time.After(5 * time.Second):
+		t.Fatal("closing waits for read-ahead of another file")
+	}
+
+	// the read-ahead of the file itself is waited for, and none is started once closing has begun
+	go func() {
+		closed <- scan.Close()
+	}()
+	select {
+	case <-closed:
+		t.Fatal("closing does not wait for read-ahead of the file")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(scanStore.release)
+	assert.ErrorIs(t, <-closed, ErrPageBeingUsed, "page is pinned while closing")
+	assert.Nil(t, scan.UnpinPage(1), "unpin page")
+	assert.Nil(t, scan.Close(), "close scanned store")
+	assert.Nil(t, pool.Close(), "close pool")
+}
@@ -0,0 +1,90 @@
+package pagedfile
+
+import "pkg/extio"
+
+// Changes the number of frames of the pool to `numPages`, which must be at least 1.
+// Growing appends empty frames to the free queue. Shrinking first drops free frames, then evicts unpinned pages
+// chosen by the replacement policy, writing dirty ones back; scratch blocks are kept, like pinned pages.
+// If there are not enough frames which are free or hold an unpinned page, error `ErrTooManyPinned` is returned
+// and the pool is left as it is. If a write-back fails, its error is returned; pages evicted so far stay evicted,
+// but the pool keeps its size.
+// The replacement policy is rebuilt from the recency order of the pages, so any other history it kept is lost.
+func (bp *BufferPool) Resize(numPages int) error {
+	if numPages < 1 {
+		return ErrInvalidPoolSize
+	}
+	bp.latch.Lock()
+	err := bp.resize(numPages)
+	unsynced := bp.takeUnsynced()
+	bp.latch.Unlock()
+	if errs := syncWritten(unsynced); err == nil && len(errs) > 0 {
+		err = errs[0]
+	}
+	return err
+}
+
+// Changes the number of frames of the pool, as described in `Resize`.
+func (bp *BufferPool) resize(numPages int) error {
+	if numPages > len(bp.buffer) {
+		for i := len(bp.buffer); i < numPages; i++ {
+			frame := make([]byte, bp.pageSize)
+			page := &BufferedPage{
+				frame:     frame,
+				memBuffer: extio.NewBasicBytesIO(frame),
+				idx:       TypePoolIdx(i),
+			}
+			bp.buffer = append(bp.buffer, page)
+			bp.makeHeadFree(page)
+		}
+		bp.rebuildPolicy()
+		return nil
+	}
+
+	excess := len(bp.buffer) - numPages
+	numFree := 0
+	for pos := bp.headFree; pos != nil; pos = pos.next {
+		numFree += 1
+	}
+	numEvictable := 0
+	for pos := bp.headUsed; pos != nil; pos = pos.next {
+		if pos.pinned == 0 && !pos.loading {
+			numEvictable += 1
+		}
+	}
+	if numFree+numEvictable < excess {
+		return ErrTooManyPinned
+	}
+	for ; numFree < excess; numFree++ {
+		if _, err := bp.evictVictim(nil); err != nil {
+			return err
+		}
+	}
+
+	dropped := make(map[*BufferedPage]bool)
+	for i := 0; i < excess; i++ {
+		dropped[bp.headFree] = true
+		bp.removeFree(bp.headFree)
+	}
+	buffer := make([]*BufferedPage, 0, numPages)
+	for _, page := range bp.buffer {
+		if !dropped[page] {
+			page.idx = TypePoolIdx(len(buffer))
+			buffer = append(buffer, page)
+		}
+	}
+	bp.buffer = buffer
+	bp.rebuildPolicy()
+	if bp.flusher != nil {
+		bp.flusher.dirtied(bp.numDirty, len(bp.buffer))
+	}
+	return nil
+}
+
+// Prepares the replacement policy for the current frames, and loads the used frames into it,
+// from the least recently used one to the most recently used one.
+func (bp *BufferPool) rebuildPolicy() {
+	bp.policy.Init(len(bp.buffer))
+	for pos := bp.tailUsed; pos != nil; pos = pos.prev {
+		bp.policy.Loaded(pos.idx)
+	}
+}
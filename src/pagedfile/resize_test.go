@@ -0,0 +1,72 @@
+package pagedfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResize(t *testing.T) {
+	policies := []struct {
+		newPolicy func() ReplacementPolicy
+		desc      string
+	}{
+		{newPolicy: func() ReplacementPolicy { return NewLRUPolicy() }, desc: "LRU"},
+		{newPolicy: func() ReplacementPolicy { return NewClockPolicy() }, desc: "Clock"},
+		{newPolicy: func() ReplacementPolicy { return NewLRUKPolicy(2) }, desc: "LRU-2"},
+		{newPolicy: func() ReplacementPolicy { return NewTwoQueuePolicy() }, desc: "2Q"},
+	}
+	for _, p := range policies {
+		store := utilsNewMemFile(t, 8)
+		pool := NewBufferPoolWithOptions(PoolOptions{NumPages: 4, Policy: p.newPolicy()})
+		fh, err := pool.OpenStore(store)
+		assert.Nil(t, err, "open store", p.desc)
+
+		assert.ErrorIs(t, pool.Resize(0), ErrInvalidPoolSize, "resize to zero", p.desc)
+		assert.Nil(t, pool.Resize(6), "grow pool", p.desc)
+		assert.Equal(t, 6, pool.Stats().NumFrames, "frames after growing", p.desc)
+		utilsCheckPoolLists(t, pool, p.desc)
+
+		// pages 1 to 4 pinned, page 5 dirty
+		for i := 1; i <= 5; i++ {
+			page, err := fh.GetThisPage(TypePageNum(i))
+			assert.Nil(t, err, "get page", i, p.desc)
+			assert.Nil(t, page.WriteInt32(4, int32(10*i)), "write page", i, p.desc)
+			assert.Nil(t, fh.MarkDirty(page.PageNum()), "mark dirty", i, p.desc)
+		}
+		assert.Nil(t, fh.UnpinPage(5), "unpin page", p.desc)
+
+		assert.ErrorIs(t, pool.Resize(3), ErrTooManyPinned, "shrink below pinned pages", p.desc)
+		assert.Equal(t, 6, pool.Stats().NumFrames, "frames after failed shrinking", p.desc)
+		assert.Nil(t, pool.Resize(4), "shrink pool", p.desc)
+		stats := pool.Stats()
+		assert.Equal(t, 4, stats.NumFrames, "frames after shrinking", p.desc)
+		assert.Equal(t, 4, stats.PinnedFrames, "pinned pages are kept", p.desc)
+		assert.Equal(t, int64(1), stats.DirtyWriteBacks, "dirty page is written back", p.desc)
+		utilsCheckPoolLists(t, pool, p.desc)
+		for i, page := range pool.buffer {
+			assert.Equal(t, TypePoolIdx(i), page.idx, "frames are renumbered", i, p.desc)
+		}
+
+		for i := 1; i <= 4; i++ {
+			assert.Nil(t, fh.UnpinPage(TypePageNum(i)), "unpin page", i, p.desc)
+		}
+		for i := 1; i <= 8; i++ {
+			page, err := fh.GetThisPage(TypePageNum(i))
+			assert.Nil(t, err, "get page after resizing", i, p.desc)
+			expected := int32(0)
+			if i <= 5 {
+				expected = int32(10 * i)
+			}
+			v, err := page.ReadInt32(4)
+			assert.Nil(t, err, "read page", i, p.desc)
+			assert.Equal(t, expected, v, "content of page", i, p.desc)
+			assert.Nil(t, fh.UnpinPage(TypePageNum(i)), "unpin page", i, p.desc)
+		}
+		utilsCheckPoolLists(t, pool, p.desc)
+
+		assert.Nil(t, pool.Resize(1), "shrink to a single frame", p.desc)
+		utilsCheckPoolLists(t, pool, p.desc)
+		assert.Nil(t, fh.Close(), "close store", p.desc)
+	}
+}
@@ -0,0 +1,107 @@
+package pagedfile
+
+import "expvar"
+
+// Stats counts the events of a buffer pool, or of the pages of one file in it.
+type Stats struct {
+	Hits             int64 // requests for pages already in the pool
+	Misses           int64 // requests for pages that had to be read from disk
+	Evictions        int64 // pages evicted to make room for other pages
+	DirtyWriteBacks  int64 // evicted pages that had to be written to disk first
+	DiskReads        int64 // pages read from disk
+	DiskWrites       int64 // pages written to disk, including write-backs on eviction
+	BackgroundWrites int64 // pages written by the background writer
+	BackgroundErrors int64 // pages the background writer failed to write
+	PrefetchedPages  int64 // pages read ahead
+	PrefetchHits     int64 // pages read ahead and requested afterwards
+	PrefetchWasted   int64 // pages read ahead and evicted without being requested
+	QuotaEvictions   int64 // pages evicted because their file held as many frames as its cap
+	QuotaDenials     int64 // requests refused because their file reached its cap with every page pinned
+	ReserveDenials   int64 // requests refused because the only available frames were reserved for other files
+	UsedFrames       int   // frames holding pages at the time of the snapshot
+	PinnedFrames     int   // frames pinned at the time of the snapshot
+	DirtyFrames      int   // frames holding dirty pages at the time of the snapshot
+}
+
+// PoolStats is a snapshot of the counters of a buffer pool.
+type PoolStats struct {
+	Stats
+	NumFrames  int              // number of frames of the pool
+	FreeFrames int              // frames holding no page
+	Blocks     int              // frames handed out as scratch blocks
+	Files      map[string]Stats // counters of every opened file, by file name
+}
+
+// Returns a snapshot of the counters of the pool.
+func (bp *BufferPool) Stats() PoolStats {
+	bp.latch.Lock()
+	defer bp.latch.Unlock()
+	ret := PoolStats{
+		Stats:     bp.stats,
+		NumFrames: len(bp.buffer),
+		Blocks:    len(bp.blocks),
+		Files:     make(map[string]Stats),
+	}
+	current := make(map[*fileState]*Stats)
+	for _, state := range bp.files {
+		stats := state.stats
+		current[state] = &stats
+	}
+	for pos := bp.headFree; pos != nil; pos = pos.next {
+		ret.FreeFrames += 1
+	}
+	for pos := bp.headUsed; pos != nil; pos = pos.next {
+		stats := current[pos.file]
+		ret.UsedFrames += 1
+		if stats != nil {
+			stats.UsedFrames += 1
+		}
+		if pos.pinned > 0 {
+			ret.PinnedFrames += 1
+			if stats != nil {
+				stats.PinnedFrames += 1
+			}
+		}
+		if pos.dirty {
+			ret.DirtyFrames += 1
+			if stats != nil {
+				stats.DirtyFrames += 1
+			}
+		}
+	}
+	for state, stats := range current {
+		merged := ret.Files[state.name]
+		merged.add(stats)
+		ret.Files[state.name] = merged
+	}
+	return ret
+}
+
+// Adds the counters of `other` to `stats`.
+func (stats *Stats) add(other *Stats) {
+	stats.Hits += other.Hits
+	stats.Misses += other.Misses
+	stats.Evictions += other.Evictions
+	stats.DirtyWriteBacks += other.DirtyWriteBacks
+	stats.DiskReads += other.DiskReads
+	stats.DiskWrites += other.DiskWrites
+	stats.BackgroundWrites += other.BackgroundWrites
+	stats.BackgroundErrors += other.BackgroundErrors
+	stats.PrefetchedPages += other.PrefetchedPages
+	stats.PrefetchHits += other.PrefetchHits
+	stats.PrefetchWasted += other.PrefetchWasted
+	stats.QuotaEvictions += other.QuotaEvictions
+	stats.QuotaDenials += other.QuotaDenials
+	stats.ReserveDenials += other.ReserveDenials
+	stats.UsedFrames += other.UsedFrames
+	stats.PinnedFrames += other.PinnedFrames
+	stats.DirtyFrames += other.DirtyFrames
+}
+
+// Publishes the counters of the pool as an expvar variable with given name.
+// Like `expvar.Publish`, it panics if the name is already in use.
+func (bp *BufferPool) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return bp.Stats()
+	}))
+}
@@ -0,0 +1,196 @@
+package pagedfile
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"pkg/extio"
+)
+
+// PageStore is the storage of the pages of one paged file.
+// Pages are addressed by page number; the length of the buffer passed to `ReadPage` and `WritePage` is the page size.
+// Implementations must be safe for concurrent use.
+type PageStore interface {
+	// Name identifies the store in errors and statistics.
+	Name() string
+	// ReadPage reads a page into buf. Bytes beyond the end of the store are read as zeros.
+	ReadPage(num TypePageNum, buf []byte) error
+	// WritePage writes buf as a page, extending the store if needed.
+	WritePage(num TypePageNum, buf []byte) error
+	// WritePages writes bufs, which have the same length, as consecutive pages starting at page `num`.
+	// It returns the number of pages written entirely. If an error is returned, it must be less than `len(bufs)`,
+	// and the page it stopped at is the one which failed; callers treat the last page as failed otherwise.
+	WritePages(num TypePageNum, bufs [][]byte) (int, error)
+	// Size returns the size of the store in bytes.
+	Size() (int64, error)
+	// Sync commits written pages to stable storage.
+	Sync() error
+	// DataSync commits written pages to stable storage, like `Sync`,
+	// but may skip metadata which is not needed to read the pages back.
+	DataSync() error
+	// Close releases resources held by the store.
+	Close() error
+}
+
+// OSPageStore stores pages in a file of the operating system.
+// Pages are read and written with positional I/O, so that different pages can be accessed concurrently.
+type OSPageStore struct {
+	fi *os.File
+}
+
+func NewOSPageStore(fi *os.File) *OSPageStore {
+	return &OSPageStore{
+		fi: fi,
+	}
+}
+
+func (s *OSPageStore) Name() string {
+	return s.fi.Name()
+}
+
+// Reads a page with a single positional read.
+// A page which is partially or entirely beyond the end of the file is padded with zeros.
+func (s *OSPageStore) ReadPage(num TypePageNum, buf []byte) error {
+	n, err := s.fi.ReadAt(buf, int64(num)*int64(len(buf)))
+	if err == io.EOF {
+		for i := n; i < len(buf); i++ {
+			buf[i] = 0
+		}
+		err = nil
+	}
+	return err
+}
+
+// Writes a page with a single positional write.
+func (s *OSPageStore) WritePage(num TypePageNum, buf []byte) error {
+	_, err := s.fi.WriteAt(buf, int64(num)*int64(len(buf)))
+	return err
+}
+
+// Writes consecutive pages with vectored positional writes where they are available,
+// and with a single positional write of the concatenated pages otherwise.
+func (s *OSPageStore) WritePages(num TypePageNum, bufs [][]byte) (int, error) {
+	if len(bufs) == 0 {
+		return 0, nil
+	}
+	return writePages(s.fi, int64(num)*int64(len(bufs[0])), bufs)
+}
+
+func (s *OSPageStore) Size() (int64, error) {
+	info, err := s.fi.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (s *OSPageStore) Sync() error {
+	return s.fi.Sync()
+}
+
+// Syncs the file with fdatasync where it is available, and with fsync otherwise.
+func (s *OSPageStore) DataSync() error {
+	return dataSync(s.fi)
+}
+
+func (s *OSPageStore) Close() error {
+	return s.fi.Close()
+}
+
+// MemPageStore stores pages in memory. It grows as pages are written.
+// Closing it keeps its content, so that it can be opened again.
+type MemPageStore struct {
+	name  string
+	latch sync.RWMutex
+	mem   extio.BytesIO // capacity may exceed the size of the store
+	size  int64
+}
+
+func NewMemPageStore(name string) *MemPageStore {
+	return &MemPageStore{
+		name: name,
+		mem:  extio.NewBasicBytesIO(make([]byte, 0)),
+	}
+}
+
+func (s *MemPageStore) Name() string {
+	return s.name
+}
+
+func (s *MemPageStore) ReadPage(num TypePageNum, buf []byte) error {
+	s.latch.RLock()
+	defer s.latch.RUnlock()
+	for i := range buf {
+		buf[i] = 0
+	}
+	offset := int64(num) * int64(len(buf))
+	if offset >= s.size {
+		return nil
+	}
+	end := offset + int64(len(buf))
+	if end > s.size {
+		end = s.size
+	}
+	_, err := s.mem.ReadAt(buf[:end-offset], offset)
+	return err
+}
+
+func (s *MemPageStore) WritePage(num TypePageNum, buf []byte) error {
+	s.latch.Lock()
+	defer s.latch.Unlock()
+	return s.writePage(num, buf)
+}
+
+func (s *MemPageStore) WritePages(num TypePageNum, bufs [][]byte) (int, error) {
+	s.latch.Lock()
+	defer s.latch.Unlock()
+	for i, buf := range bufs {
+		err := s.writePage(num+TypePageNum(i), buf)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(bufs), nil
+}
+
+// Writes a page. The caller should hold the latch exclusively.
+func (s *MemPageStore) writePage(num TypePageNum, buf []byte) error {
+	offset := int64(num) * int64(len(buf))
+	end := offset + int64(len(buf))
+	if end > int64(len(s.mem.Bytes())) {
+		capacity := 2 * int64(len(s.mem.Bytes()))
+		if capacity < end {
+			capacity = end
+		}
+		grown := make([]byte, capacity)
+		copy(grown, s.mem.Bytes())
+		s.mem = extio.NewBasicBytesIO(grown)
+	}
+	_, err := s.mem.WriteAt(buf, offset)
+	if err != nil {
+		return err
+	}
+	if end > s.size {
+		s.size = end
+	}
+	return nil
+}
+
+func (s *MemPageStore) Size() (int64, error) {
+	s.latch.RLock()
+	defer s.latch.RUnlock()
+	return s.size, nil
+}
+
+func (s *MemPageStore) Sync() error {
+	return nil
+}
+
+func (s *MemPageStore) DataSync() error {
+	return nil
+}
+
+func (s *MemPageStore) Close() error {
+	return nil
+}
@@ -0,0 +1,73 @@
+package pagedfile
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func dataSync(fi *os.File) error {
+	for {
+		err := syscall.Fdatasync(int(fi.Fd()))
+		if err != syscall.EINTR {
+			if err != nil {
+				return &os.PathError{Op: "fdatasync", Path: fi.Name(), Err: err}
+			}
+			return nil
+		}
+	}
+}
+
+// Maximum number of buffers passed to a single pwritev, which is IOV_MAX on Linux.
+const maxIovecs = 1024
+
+// Writes consecutive pages starting at given offset with pwritev, going on after short writes.
+func writePages(fi *os.File, offset int64, bufs [][]byte) (int, error) {
+	conn, err := fi.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	pageSize := len(bufs[0])
+	total := pageSize * len(bufs)
+	written := 0
+	for written < total {
+		first := written / pageSize
+		iovecs := make([]syscall.Iovec, 0, maxIovecs)
+		for i := first; i < len(bufs) && len(iovecs) < maxIovecs; i++ {
+			buf := bufs[i]
+			if i == first {
+				buf = buf[written%pageSize:]
+			}
+			iovec := syscall.Iovec{Base: &buf[0]}
+			iovec.SetLen(len(buf))
+			iovecs = append(iovecs, iovec)
+		}
+		pos := offset + int64(written)
+		var n uintptr
+		var errno syscall.Errno
+		err := conn.Write(func(fd uintptr) bool {
+			n, _, errno = syscall.Syscall6(syscall.SYS_PWRITEV, fd,
+				uintptr(unsafe.Pointer(&iovecs[0])), uintptr(len(iovecs)),
+				uintptr(pos), uintptr(uint64(pos)>>(longBits-1)>>1), 0)
+			return errno != syscall.EAGAIN
+		})
+		if err == nil && errno == syscall.EINTR {
+			continue
+		}
+		if err == nil && errno != 0 {
+			err = errno
+		}
+		if err != nil {
+			return written / pageSize, &os.PathError{Op: "pwritev", Path: fi.Name(), Err: err}
+		}
+		if n == 0 {
+			return written / pageSize, io.ErrShortWrite
+		}
+		written += int(n)
+	}
+	return len(bufs), nil
+}
+
+// Number of bits of a C long, which holds half of the offset given to pwritev on 32-bit platforms.
+const longBits = 8 * unsafe.Sizeof(uintptr(0))
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package pagedfile
+
+import "os"
+
+func dataSync(fi *os.File) error {
+	return fi.Sync()
+}
+
+// Writes consecutive pages starting at given offset with a single positional write.
+func writePages(fi *os.File, offset int64, bufs [][]byte) (int, error) {
+	data := make([]byte, 0, len(bufs)*len(bufs[0]))
+	for _, buf := range bufs {
+		data = append(data, buf...)
+	}
+	n, err := fi.WriteAt(data, offset)
+	return n / len(bufs[0]), err
+}
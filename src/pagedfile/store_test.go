@@ -0,0 +1,151 @@
+package pagedfile
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPageStores(t *testing.T) {
+	testCases := []struct {
+		newStore func() PageStore
+		desc     string
+	}{
+		{
+			newStore: func() PageStore { return NewMemPageStore("test") },
+			desc:     "In-memory store",
+		},
+		{
+			newStore: func() PageStore {
+				fi, err := os.OpenFile(filepath.Join(t.TempDir(), "test.db"), os.O_CREATE|os.O_RDWR, 0600)
+				assert.Nil(t, err, "create file")
+				return NewOSPageStore(fi)
+			},
+			desc: "OS file store",
+		},
+	}
+
+	pageSize := MinPageSize
+	for _, tc := range testCases {
+		store := tc.newStore()
+		size, err := store.Size()
+		assert.Nil(t, err, "size", tc.desc)
+		assert.Equal(t, int64(0), size, "new store is empty", tc.desc)
+
+		page := make([]byte, pageSize)
+		for i := range page {
+			page[i] = byte(i)
+		}
+		assert.Nil(t, store.WritePage(2, page), "write page beyond the end", tc.desc)
+		size, err = store.Size()
+		assert.Nil(t, err, "size", tc.desc)
+		assert.Equal(t, int64(3*pageSize), size, "store grows", tc.desc)
+
+		buf := make([]byte, pageSize)
+		assert.Nil(t, store.ReadPage(2, buf), "read page", tc.desc)
+		assert.Equal(t, page, buf, "content of written page", tc.desc)
+		buf[0] = 42
+		assert.Nil(t, store.ReadPage(0, buf), "read hole", tc.desc)
+		assert.Equal(t, make([]byte, pageSize), buf, "hole is read as zeros", tc.desc)
+		buf[0] = 42
+		assert.Nil(t, store.ReadPage(5, buf), "read beyond the end", tc.desc)
+		assert.Equal(t, make([]byte, pageSize), buf, "pages beyond the end are read as zeros", tc.desc)
+
+		pages := make([][]byte, 3)
+		for i := range pages {
+			pages[i] = make([]byte, pageSize)
+			for j := range pages[i] {
+				pages[i][j] = byte(i + j)
+			}
+		}
+		n, err := store.WritePages(4, pages)
+		assert.Nil(t, err, "write pages", tc.desc)
+		assert.Equal(t, len(pages), n, "pages written", tc.desc)
+		for i := range pages {
+			assert.Nil(t, store.ReadPage(TypePageNum(4+i), buf), "read written page", i, tc.desc)
+			assert.Equal(t, pages[i], buf, "content of page written with others", i, tc.desc)
+		}
+
+		assert.Nil(t, store.Sync(), "sync", tc.desc)
+		assert.Nil(t, store.DataSync(), "data sync", tc.desc)
+		assert.Nil(t, store.Close(), "close", tc.desc)
+	}
+}
+
+func TestInMemoryFile(t *testing.T) {
+	store := NewMemPageStore("test")
+	pool := NewBufferPool(2)
+	assert.Nil(t, pool.CreateStore(store, FileOptions{Checksum: true}), "create store")
+	assert.ErrorIs(t, pool.CreateStore(store, FileOptions{}), ErrStoreNotEmpty, "create store twice")
+
+	fh, err := pool.OpenStore(store)
+	assert.Nil(t, err, "open store")
+	for i := 1; i <= 4; i++ {
+		page, err := fh.AllocatePage()
+		assert.Nil(t, err, "allocate page", i)
+		assert.Nil(t, page.WriteInt32(0, int32(i)), "write page", i)
+		assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page", i)
+	}
+	assert.Nil(t, fh.Close(), "close file")
+
+	fh, err = pool.OpenStore(store)
+	assert.Nil(t, err, "reopen store")
+	for i := 1; i <= 4; i++ {
+		page, err := fh.GetThisPage(TypePageNum(i))
+		assert.Nil(t, err, "get page", i)
+		v, err := page.ReadInt32(0)
+		assert.Nil(t, err, "read page", i)
+		assert.Equal(t, int32(i), v, "content of page", i)
+		assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page", i)
+	}
+	assert.Nil(t, fh.Close(), "close file")
+
+	_, err = pool.OpenStore(NewMemPageStore("empty"))
+	assert.ErrorIs(t, err, ErrNotPagedFile, "empty store is not a paged file")
+}
+
+func TestOSPageStore(t *testing.T) {
+	pageSize := MinPageSize
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	assert.Nil(t, os.WriteFile(fileName, []byte{1, 2, 3}, 0600), "write partial page")
+	fi, err := os.OpenFile(fileName, os.O_RDWR, 0600)
+	assert.Nil(t, err, "open file")
+	store := NewOSPageStore(fi)
+
+	buf := make([]byte, pageSize)
+	for i := range buf {
+		buf[i] = 42
+	}
+	assert.Nil(t, store.ReadPage(0, buf), "read partial page")
+	expected := make([]byte, pageSize)
+	copy(expected, []byte{1, 2, 3})
+	assert.Equal(t, expected, buf, "partial page is padded with zeros")
+
+	// concurrent reads and writes of different pages
+	numPages := 16
+	var wg sync.WaitGroup
+	for i := 1; i <= numPages; i++ {
+		wg.Add(1)
+		go func(num TypePageNum) {
+			defer wg.Done()
+			page := make([]byte, pageSize)
+			for j := range page {
+				page[j] = byte(num)
+			}
+			buf := make([]byte, pageSize)
+			for k := 0; k < 20; k++ {
+				assert.Nil(t, store.WritePage(num, page), "write page", num)
+				assert.Nil(t, store.ReadPage(num, buf), "read page", num)
+				assert.Equal(t, page, buf, "content of page", num)
+			}
+		}(TypePageNum(i))
+	}
+	wg.Wait()
+	size, err := store.Size()
+	assert.Nil(t, err, "size")
+	assert.Equal(t, int64((numPages+1)*pageSize), size, "size after writes")
+	assert.Nil(t, store.Close(), "close")
+}
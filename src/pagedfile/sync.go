@@ -0,0 +1,40 @@
+package pagedfile
+
+// SyncMode tells when pages written to a file are committed to stable storage.
+type SyncMode int
+
+const (
+	SyncNone        SyncMode = iota // pages are handed to the operating system, and never synced
+	SyncOnForce                     // forcing or releasing pages syncs the file with fsync once they are written
+	SyncDataOnForce                 // like `SyncOnForce`, but with fdatasync where it is available
+	SyncEveryWrite                  // every page written, including write-backs on eviction, is synced with fsync
+)
+
+// Sets the sync mode of the file, which is the sync mode of the pool until it is set.
+func (fh *FileHandler) SetSyncMode(mode SyncMode) {
+	fh.bufPool.latch.Lock()
+	defer fh.bufPool.latch.Unlock()
+	fh.bufPool.fileStateOf(fh.store).syncMode = mode
+}
+
+// Returns the sync mode of a file.
+func (bp *BufferPool) syncModeOf(store PageStore) SyncMode {
+	if state, ok := bp.files[store]; ok {
+		return state.syncMode
+	}
+	return bp.syncMode
+}
+
+// Makes the pages written to a store durable after they have been forced or released, as required by the sync mode.
+// In mode `SyncEveryWrite`, the store is synced as well: pages written back by others may not be durable yet,
+// since their syncs run after the pool latch is released.
+func syncForced(store PageStore, mode SyncMode) error {
+	var err error
+	switch mode {
+	case SyncOnForce, SyncEveryWrite:
+		err = store.Sync()
+	case SyncDataOnForce:
+		err = store.DataSync()
+	}
+	return wrapPageError("sync", store.Name(), NonExistPageNum, err)
+}
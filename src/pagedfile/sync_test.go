@@ -0,0 +1,194 @@
+package pagedfile
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncModes(t *testing.T) {
+	testCases := []struct {
+		mode      SyncMode
+		perFile   bool // set the mode on the file instead of the pool
+		syncs     int  // fsyncs after allocating pages and forcing them
+		dataSyncs int  // fdatasyncs after allocating pages and forcing them
+		durable   bool // whether forced pages survive a crash
+		desc      string
+	}{
+		{mode: SyncNone, syncs: 0, dataSyncs: 0, durable: false, desc: "No sync"},
+		{mode: SyncOnForce, syncs: 1, dataSyncs: 0, durable: true, desc: "Fsync on force"},
+		{mode: SyncDataOnForce, syncs: 0, dataSyncs: 1, durable: true, desc: "Fdatasync on force"},
+		{mode: SyncOnForce, perFile: true, syncs: 1, dataSyncs: 0, durable: true, desc: "Fsync on force set on the file"},
+		// pages 1 to 3 are written back on eviction, then the header and pages 4 and 5 are forced in two writes,
+		// after which the file is synced once for the writes, and once for forcing
+		{mode: SyncEveryWrite, syncs: 5, dataSyncs: 0, durable: true, desc: "Sync every write"},
+	}
+
+	numFilePages := 5
+	for _, tc := range testCases {
+		store := NewFaultyStore(NewMemPageStore("test"))
+		opts := PoolOptions{NumPages: 3}
+		if !tc.perFile {
+			opts.SyncMode = tc.mode
+		}
+		pool := NewBufferPoolWithOptions(opts)
+		assert.Nil(t, pool.CreateStore(store, FileOptions{PageSize: MinPageSize}), "create store", tc.desc)
+		assert.Nil(t, store.Sync(), "sync created store", tc.desc)
+		fh, err := pool.OpenStore(store)
+		assert.Nil(t, err, "open store", tc.desc)
+		if tc.perFile {
+			fh.SetSyncMode(tc.mode)
+		}
+		syncs, dataSyncs := store.Ops(OpSync), store.Ops(OpDataSync)
+
+		for i := 1; i <= numFilePages; i++ {
+			page, err := fh.AllocatePage()
+			assert.Nil(t, err, "allocate page", i, tc.desc)
+			assert.Nil(t, page.WriteInt32(0, int32(i)), "write page", i, tc.desc)
+			assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page", i, tc.desc)
+		}
+		assert.Nil(t, fh.ForcePages(), "force pages", tc.desc)
+		assert.Equal(t, tc.syncs, store.Ops(OpSync)-syncs, "fsyncs", tc.desc)
+		assert.Equal(t, tc.dataSyncs, store.Ops(OpDataSync)-dataSyncs, "fdatasyncs", tc.desc)
+
+		store.Crash()
+		buf := make([]byte, MinPageSize)
+		for i := 1; i <= numFilePages; i++ {
+			assert.Nil(t, store.ReadPage(TypePageNum(i), buf), "read page after crash", i, tc.desc)
+			expected := int32(0)
+			if tc.durable {
+				expected = int32(i)
+			}
+			assert.Equal(t, expected, int32(RWBytesOrder.Uint32(buf[pageHeaderSize:])), "content of page after crash", i, tc.desc)
+		}
+		assert.Nil(t, fh.Close(), "close store", tc.desc)
+	}
+}
+
+func TestSyncFailure(t *testing.T) {
+	store := NewFaultyStore(NewMemPageStore("test"))
+	pool := NewBufferPoolWithOptions(PoolOptions{NumPages: 4, SyncMode: SyncDataOnForce})
+	assert.Nil(t, pool.CreateStore(store, FileOptions{}), "create store")
+	fh, err := pool.OpenStore(store)
+	assert.Nil(t, err, "open store")
+	page, err := fh.AllocatePage()
+	assert.Nil(t, err, "allocate page")
+	assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page")
+
+	store.Inject(Fault{Op: OpDataSync, Kind: FaultSyncError, Count: 1})
+	assert.ErrorIs(t, fh.ForcePage(page.PageNum()), syscall.EIO, "forcing a page fails to sync")
+	assert.Nil(t, fh.ForcePage(page.PageNum()), "clean page is synced again")
+	store.Inject(Fault{Op: OpDataSync, Kind: FaultSyncError, Count: 1})
+	assert.ErrorIs(t, fh.Close(), syscall.EIO, "closing fails to sync")
+	assert.Nil(t, fh.Close(), "close store")
+	assert.Equal(t, 2, store.Failed(OpDataSync), "failed syncs")
+}
+
+// blockingSyncStore blocks the first sync after `block` is set, until `release` is closed.
+type blockingSyncStore struct {
+	PageStore
+	block   bool
+	syncing chan struct{} // closed when the blocked sync starts
+	release chan struct{}
+}
+
+func (s *blockingSyncStore) Sync() error {
+	if s.block {
+		s.block = false
+		close(s.syncing)
+		<-s.release
+	}
+	return s.PageStore.Sync()
+}
+
+func TestSyncWithoutLatch(t *testing.T) {
+	store := &blockingSyncStore{
+		PageStore: utilsNewMemFile(t, 4),
+		syncing:   make(chan struct{}),
+		release:   make(chan struct{}),
+	}
+	pool := NewBufferPoolWithOptions(PoolOptions{NumPages: 2, SyncMode: SyncEveryWrite})
+	fh, err := pool.OpenStore(store)
+	assert.Nil(t, err, "open store")
+	page, err := fh.GetThisPage(1)
+	assert.Nil(t, err, "get page 1")
+	assert.Nil(t, page.WriteInt32(0, 42), "write page 1")
+	assert.Nil(t, fh.MarkDirty(1), "mark dirty")
+	assert.Nil(t, fh.UnpinPage(1), "unpin page 1")
+	_, err = fh.GetThisPage(2)
+	assert.Nil(t, err, "get page 2, evicting the header page")
+
+	// the miss on page 3 writes back page 1, and blocks while syncing
+	store.block = true
+	missed := make(chan error)
+	go func() {
+		_, err := fh.GetThisPage(3)
+		missed <- err
+	}()
+	<-store.syncing
+	hit := make(chan error)
+	go func() {
+		_, err := fh.GetThisPage(2)
+		hit <- err
+	}()
+	select {
+	case err = <-hit:
+		assert.Nil(t, err, "hit while syncing")
+	case <-time.After(5 * time.Second):
+		t.Fatal("hit is blocked by sync")
+	}
+	close(store.release)
+	assert.Nil(t, <-missed, "miss after sync")
+	for _, num := range []TypePageNum{2, 2, 3} {
+		assert.Nil(t, fh.UnpinPage(num), "unpin page", num)
+	}
+	assert.Nil(t, fh.Close(), "close store")
+}
+
+func TestForceWithPendingSync(t *testing.T) {
+	faulty := NewFaultyStore(utilsNewMemFile(t, 4))
+	store := &blockingSyncStore{
+		PageStore: faulty,
+		syncing:   make(chan struct{}),
+		release:   make(chan struct{}),
+	}
+	pool := NewBufferPoolWithOptions(PoolOptions{NumPages: 2, SyncMode: SyncEveryWrite})
+	fh, err := pool.OpenStore(store)
+	assert.Nil(t, err, "open store")
+	page, err := fh.GetThisPage(1)
+	assert.Nil(t, err, "get page 1")
+	assert.Nil(t, page.WriteInt32(0, 42), "write page 1")
+	assert.Nil(t, fh.MarkDirty(1), "mark dirty")
+	assert.Nil(t, fh.UnpinPage(1), "unpin page 1")
+	_, err = fh.GetThisPage(2)
+	assert.Nil(t, err, "get page 2, evicting the header page")
+	assert.Nil(t, fh.UnpinPage(2), "unpin page 2")
+
+	// shrinking the pool writes back page 1, and blocks while syncing
+	store.block = true
+	resized := make(chan error)
+	go func() {
+		resized <- pool.Resize(1)
+	}()
+	<-store.syncing
+	assert.Nil(t, fh.ForcePages(), "force pages while a write-back is being synced")
+	faulty.Crash()
+	buf := make([]byte, PageSize)
+	assert.Nil(t, faulty.ReadPage(1, buf), "read page after crash")
+	assert.Equal(t, int32(42), int32(RWBytesOrder.Uint32(buf[pageHeaderSize:])), "written-back page is durable once forced")
+
+	closed := make(chan error)
+	go func() {
+		closed <- fh.Close()
+	}()
+	select {
+	case <-closed:
+		t.Fatal("closing does not wait for the pending sync")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(store.release)
+	assert.Nil(t, <-resized, "resize after sync")
+	assert.Nil(t, <-closed, "close store after sync")
+}
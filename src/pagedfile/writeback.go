@@ -0,0 +1,120 @@
+package pagedfile
+
+import "sort"
+
+// Maximum number of pages merged into a single write.
+const maxWriteRun = 64
+
+// Writes back dirty pages, sorted by page number within each file. Pages of the same file with consecutive
+// page numbers are merged into runs of at most `maxWriteRun` pages, and each run is written with a single write.
+// Pages failing to be written stay dirty; the errors of all failures are returned.
+func (bp *BufferPool) writeBackPages(pages []*BufferedPage) []error {
+	var errs []error
+	for _, run := range sortedRuns(pages) {
+		errs = append(errs, bp.writeBackRun(run)...)
+	}
+	return errs
+}
+
+// Groups pages by file, in order of first appearance, sorts them by page number within each file,
+// and splits them into runs of at most `maxWriteRun` pages with consecutive page numbers.
+func sortedRuns(pages []*BufferedPage) [][]*BufferedPage {
+	var stores []PageStore
+	byStore := make(map[PageStore][]*BufferedPage)
+	for _, page := range pages {
+		if _, ok := byStore[page.store]; !ok {
+			stores = append(stores, page.store)
+		}
+		byStore[page.store] = append(byStore[page.store], page)
+	}
+	var runs [][]*BufferedPage
+	for _, store := range stores {
+		sorted := byStore[store]
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].num < sorted[j].num
+		})
+		for len(sorted) > 0 {
+			n := 1
+			for n < len(sorted) && n < maxWriteRun && sorted[n].num == sorted[n-1].num+1 {
+				n += 1
+			}
+			runs = append(runs, sorted[:n])
+			sorted = sorted[n:]
+		}
+	}
+	return runs
+}
+
+// Writes back dirty pages of the same file with consecutive page numbers, with a single write of the store.
+// If the write fails partway, the page it stopped at stays dirty, and the following pages are written again as a run.
+// In mode `SyncEveryWrite`, the file is recorded to be synced once the pool latch is released, see `takeUnsynced`.
+func (bp *BufferPool) writeBackRun(pages []*BufferedPage) []error {
+	first := pages[0]
+	bufs := make([][]byte, len(pages))
+	for i, page := range pages {
+		page.prepareWrite()
+		bufs[i] = page.memBuffer.Bytes()
+	}
+	bp.writeLatch.Lock()
+	n, err := first.store.WritePages(first.num, bufs)
+	bp.writeLatch.Unlock()
+	if err != nil && n >= len(pages) {
+		n = len(pages) - 1
+	}
+	attempted := len(pages)
+	if err != nil {
+		attempted = n + 1
+	}
+	bp.stats.DiskWrites += int64(attempted)
+	first.file.stats.DiskWrites += int64(attempted)
+	for _, page := range pages[:n] {
+		page.dirty = false
+		bp.numDirty -= 1
+	}
+
+	if n > 0 && first.file.syncMode == SyncEveryWrite {
+		if bp.unsynced == nil {
+			bp.unsynced = make(map[PageStore]*fileState)
+		}
+		bp.unsynced[first.store] = first.file
+	}
+	var errs []error
+	if err != nil {
+		errs = append(errs, pages[n].wrapError("write", err))
+		if n+1 < len(pages) {
+			errs = append(errs, bp.writeBackRun(pages[n+1:])...)
+		}
+	}
+	return errs
+}
+
+// pendingSync is a file written in mode `SyncEveryWrite`, which is synced after the pool latch is released.
+type pendingSync struct {
+	store PageStore
+	file  *fileState
+}
+
+// Returns the files written in mode `SyncEveryWrite` since the last call, and forgets about them.
+// Whoever writes pages back takes them before releasing the pool latch, and syncs them with `syncWritten` afterwards,
+// so that other requests are not held up by syncing. Closing a file waits for its pending syncs.
+func (bp *BufferPool) takeUnsynced() []pendingSync {
+	pending := make([]pendingSync, 0, len(bp.unsynced))
+	for store, file := range bp.unsynced {
+		file.syncing.Add(1)
+		pending = append(pending, pendingSync{store, file})
+	}
+	bp.unsynced = nil
+	return pending
+}
+
+// Syncs files returned by `takeUnsynced`, without holding the pool latch, and returns the errors of the failures.
+func syncWritten(pending []pendingSync) []error {
+	var errs []error
+	for _, p := range pending {
+		if err := p.store.Sync(); err != nil {
+			errs = append(errs, wrapPageError("sync", p.store.Name(), NonExistPageNum, err))
+		}
+		p.file.syncing.Done()
+	}
+	return errs
+}
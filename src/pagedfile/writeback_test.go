@@ -0,0 +1,116 @@
+package pagedfile
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoalescedWriteBack(t *testing.T) {
+	store := NewFaultyStore(NewMemPageStore("test"))
+	pool := NewBufferPool(16)
+	assert.Nil(t, pool.CreateStore(store, FileOptions{PageSize: MinPageSize, Checksum: true}), "create store")
+	fh, err := pool.OpenStore(store)
+	assert.Nil(t, err, "open store")
+	for i := 1; i <= 10; i++ {
+		page, err := fh.AllocatePage()
+		assert.Nil(t, err, "allocate page", i)
+		assert.Nil(t, page.WriteInt32(0, int32(i)), "write page", i)
+		assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page", i)
+	}
+	assert.Nil(t, fh.ForcePages(), "force pages")
+
+	// two runs: the header with pages 1 to 4, then pages 6 to 10 with the newly allocated page 11
+	for _, num := range []TypePageNum{1, 2, 3, 4, 6, 7, 8, 9, 10} {
+		page, err := fh.GetThisPage(num)
+		assert.Nil(t, err, "get page", num)
+		assert.Nil(t, page.WriteInt32(0, int32(10*num)), "write page", num)
+		assert.Nil(t, fh.MarkDirty(num), "mark dirty", num)
+		assert.Nil(t, fh.UnpinPage(num), "unpin page", num)
+	}
+	page, err := fh.AllocatePage()
+	assert.Nil(t, err, "allocate page")
+	assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page")
+	runs, writes := store.Ops(OpWritePages), store.Ops(OpWrite)
+	assert.Nil(t, fh.ForcePages(), "force pages")
+	assert.Equal(t, 2, store.Ops(OpWritePages)-runs, "one write per run")
+	assert.Equal(t, 11, store.Ops(OpWrite)-writes, "pages written")
+
+	for _, num := range []TypePageNum{2, 3, 4} {
+		page, err := fh.GetThisPage(num)
+		assert.Nil(t, err, "get page", num)
+		assert.Nil(t, fh.MarkDirty(num), "mark dirty", num)
+		assert.Nil(t, fh.UnpinPage(page.PageNum()), "unpin page", num)
+	}
+	store.Inject(Fault{Op: OpWrite, Kind: FaultNoSpace, Pages: []TypePageNum{3}, Count: 1})
+	runs = store.Ops(OpWritePages)
+	err = fh.ForcePages()
+	var pageErr *PageError
+	assert.True(t, errors.As(err, &pageErr), "error is a PageError")
+	assert.Equal(t, TypePageNum(3), pageErr.Page, "failed page")
+	assert.ErrorIs(t, err, syscall.ENOSPC, "underlying error")
+	assert.Equal(t, 2, store.Ops(OpWritePages)-runs, "pages after the failed one are written again as a run")
+	for _, num := range []TypePageNum{2, 3, 4} {
+		assert.Equal(t, num == 3, pool.cache[store][num].dirty, "only the failed page is dirty", num)
+	}
+	assert.Nil(t, fh.Close(), "close store")
+
+	fh, err = pool.OpenStore(store)
+	assert.Nil(t, err, "reopen store")
+	for num := TypePageNum(1); num <= 10; num++ {
+		page, err := fh.GetThisPage(num)
+		assert.Nil(t, err, "get page with checksum", num)
+		v, err := page.ReadInt32(0)
+		assert.Nil(t, err, "read page", num)
+		expected := int32(10 * num)
+		if num == 5 {
+			expected = 5
+		}
+		assert.Equal(t, expected, v, "content of page", num)
+		assert.Nil(t, fh.UnpinPage(num), "unpin page", num)
+	}
+	assert.Nil(t, fh.Close(), "close store")
+}
+
+// overreportingStore fails every write of pages after writing them, and reports all of them as written.
+type overreportingStore struct {
+	PageStore
+	fail bool
+}
+
+func (s *overreportingStore) WritePages(num TypePageNum, bufs [][]byte) (int, error) {
+	n, err := s.PageStore.WritePages(num, bufs)
+	if err == nil && s.fail {
+		err = syscall.EIO
+	}
+	return n, err
+}
+
+func TestWriteBackOverreported(t *testing.T) {
+	store := &overreportingStore{PageStore: utilsNewMemFile(t, 3)}
+	pool := NewBufferPool(8)
+	fh, err := pool.OpenStore(store)
+	assert.Nil(t, err, "open store")
+	for num := TypePageNum(1); num <= 3; num++ {
+		_, err := fh.GetThisPage(num)
+		assert.Nil(t, err, "get page", num)
+		assert.Nil(t, fh.MarkDirty(num), "mark dirty", num)
+		assert.Nil(t, fh.UnpinPage(num), "unpin page", num)
+	}
+
+	store.fail = true
+	err = fh.ForcePages()
+	assert.ErrorIs(t, err, syscall.EIO, "write error")
+	for num := TypePageNum(1); num <= 3; num++ {
+		assert.Equal(t, num == 3, pool.cache[store][num].dirty, "the last page is taken as failed", num)
+	}
+	pool.flushDirtyPages(make(chan struct{}))
+	stats := pool.Stats()
+	assert.Equal(t, int64(1), stats.BackgroundErrors, "failed background write")
+	assert.Equal(t, 1, stats.DirtyFrames, "failed page stays dirty")
+
+	store.fail = false
+	assert.Nil(t, fh.Close(), "close store")
+}
@@ -11,6 +11,7 @@ type BytesIO interface {
 	io.ReaderAt
 	io.WriterAt
 	Clear()
+	Bytes() []byte
 }
 
 type basicBytesIO struct {
@@ -37,6 +38,9 @@ func (m *basicBytesIO) Read(p []byte) (int, error) {
 func (m *basicBytesIO) ReadFrom(r io.Reader) (int64, error) {
 	n, err := r.Read(m.internal[m.offset:])
 	m.offset += n
+	if err == io.EOF { // as required by io.ReaderFrom, EOF is not an error
+		err = nil
+	}
 	return int64(n), err
 }
 
@@ -97,3 +101,8 @@ func (m *basicBytesIO) Clear() {
 		m.internal[i] = 0
 	}
 }
+
+// Returns the underlying byte slice. Modifications to it are visible to the BytesIO.
+func (m *basicBytesIO) Bytes() []byte {
+	return m.internal
+}